@@ -0,0 +1,3 @@
+// Package v1alpha1 contains the API types of the
+// namespace-node-affinity.idgenchev.github.com/v1alpha1 group version
+package v1alpha1
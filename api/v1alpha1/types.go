@@ -0,0 +1,239 @@
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// GroupVersion is the group version of the NamespaceAffinityPolicy API
+var GroupVersion = schema.GroupVersion{Group: "namespace-node-affinity.idgenchev.github.com", Version: "v1alpha1"}
+
+// NamespaceAffinityPolicyResource is the resource of the
+// NamespaceAffinityPolicy objects
+var NamespaceAffinityPolicyResource = GroupVersion.WithResource("namespaceaffinitypolicies")
+
+// NamespaceAffinityPolicy kinds
+const (
+	NamespaceAffinityPolicyKind     = "NamespaceAffinityPolicy"
+	NamespaceAffinityPolicyListKind = "NamespaceAffinityPolicyList"
+)
+
+// NamespaceAffinityPolicy condition types and reasons
+const (
+	ConditionValid             = "Valid"
+	ReasonValid                = "Valid"
+	ReasonInvalidConfiguration = "InvalidConfiguration"
+)
+
+// NamespaceAffinityPolicy is a cluster-scoped policy holding the node
+// affinity and tolerations for the namespace with the same name as the
+// policy or for the namespaces matching its namespaceSelector
+type NamespaceAffinityPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NamespaceAffinityPolicySpec   `json:"spec"`
+	Status NamespaceAffinityPolicyStatus `json:"status,omitempty"`
+}
+
+// NamespaceAffinityPolicyList is a list of NamespaceAffinityPolicy objects
+type NamespaceAffinityPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []NamespaceAffinityPolicy `json:"items"`
+}
+
+// NamespaceAffinityPolicySpec is the per-namespace configuration. The same
+// schema is used for the entries of the ConfigMap
+type NamespaceAffinityPolicySpec struct {
+	NodeSelectorTerms          []corev1.NodeSelectorTerm        `json:"nodeSelectorTerms"`
+	PreferredNodeSelectorTerms []corev1.PreferredSchedulingTerm `json:"preferredNodeSelectorTerms"`
+	Tolerations                []corev1.Toleration              `json:"tolerations"`
+	ExcludedLabels             map[string]string                `json:"excludedLabels"`
+	// PodAffinity terms are added to the podAffinity of every pod
+	PodAffinity *corev1.PodAffinity `json:"podAffinity,omitempty"`
+	// PodAntiAffinity terms are added to the podAntiAffinity of every pod
+	PodAntiAffinity *corev1.PodAntiAffinity `json:"podAntiAffinity,omitempty"`
+	// TopologySpreadConstraints are added to the topologySpreadConstraints of
+	// every pod
+	TopologySpreadConstraints []TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+	// PriorityClassName is set on the pods without a priorityClassName
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+	// RuntimeClassName is set on the pods without a runtimeClassName
+	RuntimeClassName string `json:"runtimeClassName,omitempty"`
+	// SchedulerName is set on the pods using the default scheduler
+	SchedulerName string `json:"schedulerName,omitempty"`
+	// Override sets PriorityClassName, RuntimeClassName and SchedulerName
+	// even on the pods which set their own
+	Override *bool `json:"override,omitempty"`
+	// NodeSelector is merged into the nodeSelector of every pod
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// NodeSelectorConflictPolicy selects how keys of NodeSelector the pod
+	// already has with a different value are handled
+	NodeSelectorConflictPolicy NodeSelectorConflictPolicy `json:"nodeSelectorConflictPolicy,omitempty"`
+	// ExcludeSelector leaves the pods matching it unmodified. It is evaluated
+	// in addition to ExcludedLabels
+	ExcludeSelector *metav1.LabelSelector `json:"excludeSelector,omitempty"`
+	// ExcludedOwnerKinds leaves the pods with an owner reference of any of
+	// these kinds unmodified. When unset, pods owned by a DaemonSet are
+	// excluded
+	ExcludedOwnerKinds []string `json:"excludedOwnerKinds,omitempty"`
+	// ExcludedServiceAccounts leaves the pods running as any of these service
+	// accounts unmodified
+	ExcludedServiceAccounts []string `json:"excludedServiceAccounts,omitempty"`
+	// ExcludedAnnotations leaves the pods with all of these annotations
+	// unmodified
+	ExcludedAnnotations map[string]string `json:"excludedAnnotations,omitempty"`
+	// ExcludeMirrorPods leaves the mirror pods of static pods unmodified.
+	// Defaults to true
+	ExcludeMirrorPods *bool `json:"excludeMirrorPods,omitempty"`
+	// MergeDefault makes the entry extend the "_default" entry of the
+	// ConfigMap instead of replacing it. When unset, the value from the
+	// "_default" entry is used
+	MergeDefault *bool `json:"mergeDefault,omitempty"`
+	// NamespaceSelector makes the entry apply to every namespace with
+	// matching labels instead of the namespace with the same name as the
+	// entry
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// Use is the list of profiles from the "_profiles" entry of the ConfigMap
+	// which are composed, in order, before the rest of the entry
+	Use []string `json:"use,omitempty"`
+	// Rules are applied to the pods matching their selector instead of the
+	// terms and tolerations of the entry
+	Rules []PodRule `json:"rules,omitempty"`
+	// RuleMatching selects whether only the first ("first", the default) or
+	// all ("all") matching rules are applied
+	RuleMatching RuleMatching `json:"ruleMatching,omitempty"`
+	// NodeSelectorTermsStrategy selects how the nodeSelectorTerms are
+	// combined with the nodeSelectorTerms the pod already has
+	NodeSelectorTermsStrategy NodeSelectorTermsStrategy `json:"nodeSelectorTermsStrategy,omitempty"`
+	// ConflictStrategy selects how pods which already have node affinity of
+	// their own are handled
+	ConflictStrategy ConflictStrategy `json:"conflictStrategy,omitempty"`
+	// TolerationSecondsPolicy selects the tolerationSeconds of NoExecute
+	// tolerations the pod already has with different tolerationSeconds
+	TolerationSecondsPolicy TolerationSecondsPolicy `json:"tolerationSecondsPolicy,omitempty"`
+	// Mode selects whether the pods are patched ("enforce", the default) or
+	// admitted unmodified with the would-be patch reported ("audit")
+	Mode Mode `json:"mode,omitempty"`
+}
+
+// ConflictStrategy is the way pods which already have node affinity are
+// handled
+type ConflictStrategy string
+
+// ConflictStrategy values
+const (
+	// ConflictStrategyAppend adds the node affinity of the config to the node
+	// affinity of the pod
+	ConflictStrategyAppend ConflictStrategy = "append"
+	// ConflictStrategyReplace replaces the node affinity of the pod with the
+	// node affinity of the config
+	ConflictStrategyReplace ConflictStrategy = "replace"
+	// ConflictStrategySkip leaves the pod unmodified
+	ConflictStrategySkip ConflictStrategy = "skip"
+	// ConflictStrategyReject denies the admission of the pod
+	ConflictStrategyReject ConflictStrategy = "reject"
+)
+
+// NodeSelectorTermsStrategy is the way the nodeSelectorTerms of the config are
+// combined with the nodeSelectorTerms of a pod
+type NodeSelectorTermsStrategy string
+
+// NodeSelectorTermsStrategy values
+const (
+	// NodeSelectorTermsStrategyAppend adds the terms of the config to the
+	// terms of the pod. As nodeSelectorTerms are ORed, the pod can be
+	// scheduled on nodes matching only its own terms
+	NodeSelectorTermsStrategyAppend NodeSelectorTermsStrategy = "append"
+	// NodeSelectorTermsStrategyEnforce adds the requirements of the config to
+	// every term of the pod, so the pod can only be scheduled on nodes
+	// matching the terms of the config
+	NodeSelectorTermsStrategyEnforce NodeSelectorTermsStrategy = "enforce"
+)
+
+// RuleMatching is the way the rules matching a pod are applied
+type RuleMatching string
+
+// RuleMatching values
+const (
+	RuleMatchingFirst RuleMatching = "first"
+	RuleMatchingAll   RuleMatching = "all"
+)
+
+// PodRule holds the terms and tolerations for the pods matching its
+// selector. Each of the terms and tolerations set by a matching rule replaces
+// the corresponding terms or tolerations of the entry
+type PodRule struct {
+	// Name identifies the rule
+	Name string `json:"name,omitempty"`
+	// Selector is matched against the labels of the pods
+	Selector                   *metav1.LabelSelector            `json:"selector"`
+	NodeSelectorTerms          []corev1.NodeSelectorTerm        `json:"nodeSelectorTerms,omitempty"`
+	PreferredNodeSelectorTerms []corev1.PreferredSchedulingTerm `json:"preferredNodeSelectorTerms,omitempty"`
+	Tolerations                []corev1.Toleration              `json:"tolerations,omitempty"`
+}
+
+// TopologySpreadConstraint is a topologySpreadConstraint with a labelSelector
+// which can be completed from the labels of the pod it is added to
+type TopologySpreadConstraint struct {
+	corev1.TopologySpreadConstraint `json:",inline"`
+	// PodLabelKeys are the keys of the labels of the pod which are added,
+	// with the values of the pod, to the matchLabels of the labelSelector
+	PodLabelKeys []string `json:"podLabelKeys,omitempty"`
+}
+
+// NodeSelectorConflictPolicy is the way keys of the nodeSelector of the
+// config the pod already has with a different value are handled
+type NodeSelectorConflictPolicy string
+
+// NodeSelectorConflictPolicy values
+const (
+	// NodeSelectorConflictPolicyReject denies the admission of the pod
+	NodeSelectorConflictPolicyReject NodeSelectorConflictPolicy = "reject"
+	// NodeSelectorConflictPolicyReport keeps the values of the pod and
+	// reports the conflicting keys in a warning
+	NodeSelectorConflictPolicyReport NodeSelectorConflictPolicy = "report"
+)
+
+// TolerationSecondsPolicy is the way the tolerationSeconds of a NoExecute
+// toleration of the config are merged into a matching toleration of a pod
+type TolerationSecondsPolicy string
+
+// TolerationSecondsPolicy values. A missing tolerationSeconds is longer than
+// any tolerationSeconds
+const (
+	// TolerationSecondsPolicyKeep keeps the tolerationSeconds of the pod
+	TolerationSecondsPolicyKeep TolerationSecondsPolicy = "keep"
+	// TolerationSecondsPolicyReplace uses the tolerationSeconds of the config
+	TolerationSecondsPolicyReplace TolerationSecondsPolicy = "replace"
+	// TolerationSecondsPolicyMax uses the longer tolerationSeconds
+	TolerationSecondsPolicyMax TolerationSecondsPolicy = "max"
+	// TolerationSecondsPolicyMin uses the shorter tolerationSeconds
+	TolerationSecondsPolicyMin TolerationSecondsPolicy = "min"
+)
+
+// Mode is the way the configuration is applied to the pods
+type Mode string
+
+// Mode values
+const (
+	// ModeEnforce patches the pods and denies the admission of the pods
+	// according to the strategies of the config
+	ModeEnforce Mode = "enforce"
+	// ModeAudit admits the pods unmodified and reports the would-be patch
+	// and denial in the audit annotations and warnings of the response
+	ModeAudit Mode = "audit"
+)
+
+// NamespaceAffinityPolicyStatus is the observed state of a
+// NamespaceAffinityPolicy
+type NamespaceAffinityPolicyStatus struct {
+	// ObservedGeneration is the generation of the spec the conditions were
+	// set for
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions holds the "Valid" condition of the policy
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
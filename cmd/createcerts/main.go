@@ -17,15 +17,23 @@ import (
 
 	"github.com/idgenchev/namespace-node-affinity/webhookconfig"
 	"github.com/jessevdk/go-flags"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	k8sclient "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
 
 var opts struct {
-	Namespace   string `long:"namespace" short:"n" env:"NAMESPACE" default:"namespace-node-affinity" description:"The namespace where the namespace-node-affinity webhook is deployed"`
-	ServiceName string `long:"service-name" short:"s" env:"SERVICE_NAME" default:"namespace-node-affinity" description:"Name of the service object for the namespace-node-affinity"`
-	CertFile    string `lond:"cert" short:"c" env:"CERT" default:"/etc/webhook/certs/tls.crt" description:"Path to the cert file"`
-	KeyFile     string `lond:"key" short:"k" env:"KEY" default:"/etc/webhook/certs/tls.key" description:"Path to the key file"`
+	Namespace          string   `long:"namespace" short:"n" env:"NAMESPACE" default:"namespace-node-affinity" description:"The namespace where the namespace-node-affinity webhook is deployed"`
+	ServiceName        string   `long:"service-name" short:"s" env:"SERVICE_NAME" default:"namespace-node-affinity" description:"Name of the service object for the namespace-node-affinity"`
+	CertFile           string   `lond:"cert" short:"c" env:"CERT" default:"/etc/webhook/certs/tls.crt" description:"Path to the cert file"`
+	KeyFile            string   `lond:"key" short:"k" env:"KEY" default:"/etc/webhook/certs/tls.key" description:"Path to the key file"`
+	ReinvocationPolicy string   `long:"reinvocation-policy" env:"REINVOCATION_POLICY" default:"Never" choice:"Never" choice:"IfNeeded" description:"Reinvocation policy of the mutating webhook"`
+	MutateWorkloads    bool     `long:"mutate-workloads" env:"MUTATE_WORKLOADS" description:"Also register the mutating webhook for Deployments, StatefulSets, DaemonSets, ReplicaSets, Jobs and CronJobs"`
+	CustomResources    []string `long:"custom-resource" env:"CUSTOM_RESOURCES" env-delim:"," description:"Also register the mutating webhook for the custom resource (eg: rayclusters.v1.ray.io). Can be repeated"`
+
+	ValidatingWebhook       bool   `long:"validating-webhook" env:"VALIDATING_WEBHOOK" description:"Also register a validating webhook rejecting pods which violate the placement policy of their namespace"`
+	ValidatingFailurePolicy string `long:"validating-failure-policy" env:"VALIDATING_FAILURE_POLICY" default:"Ignore" choice:"Ignore" choice:"Fail" description:"Failure policy of the validating webhook"`
 }
 
 const (
@@ -79,10 +87,27 @@ func main() {
 		log.Fatalf("Failed to create k8s client: %s", err)
 	}
 
-	if err = webhookconfig.CreateOrUpdateMutatingWebhookConfig(clientset, caPEM, opts.Namespace, webhookConfigName, opts.ServiceName); err != nil {
+	var customResources []schema.GroupVersionResource
+	for _, arg := range opts.CustomResources {
+		gvr, _ := schema.ParseResourceArg(arg)
+		if gvr == nil {
+			log.Fatalf("Invalid custom resource %s, expected resource.version.group", arg)
+		}
+		customResources = append(customResources, *gvr)
+	}
+
+	reinvocationPolicy := admissionregistrationv1.ReinvocationPolicyType(opts.ReinvocationPolicy)
+	if err = webhookconfig.CreateOrUpdateMutatingWebhookConfig(clientset, caPEM, opts.Namespace, webhookConfigName, opts.ServiceName, reinvocationPolicy, opts.MutateWorkloads, customResources); err != nil {
 		log.Fatalf("Failed to create mutating webhook config: %s", err)
 	}
 
+	if opts.ValidatingWebhook {
+		failurePolicy := admissionregistrationv1.FailurePolicyType(opts.ValidatingFailurePolicy)
+		if err = webhookconfig.CreateOrUpdateValidatingWebhookConfig(clientset, caPEM, opts.Namespace, webhookConfigName, opts.ServiceName, failurePolicy); err != nil {
+			log.Fatalf("Failed to create validating webhook config: %s", err)
+		}
+	}
+
 	commonName := fmt.Sprintf("%s.%s.svc", opts.ServiceName, opts.Namespace)
 	dnsNames := []string{
 		opts.ServiceName,
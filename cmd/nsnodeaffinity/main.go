@@ -12,23 +12,28 @@ import (
 	"github.com/jessevdk/go-flags"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/dynamic"
 	k8sclient "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
 
 var opts struct {
-	Port          int           `long:"port" short:"p" env:"PORT" default:"8443" description:"The port on which to serve."`
-	ReadTimeout   time.Duration `long:"read-timeout" default:"10s" description:"Read timeout"`
-	WriteTimeout  time.Duration `long:"write-timeout" default:"10s" description:"Write timeout"`
-	CertFile      string        `lond:"cert" short:"c" env:"CERT" default:"/etc/webhook/certs/tls.crt" description:"Path to the cert file"`
-	KeyFile       string        `lond:"key" short:"k" env:"KEY" default:"/etc/webhook/certs/tls.key" description:"Path to the key file"`
-	Namespace     string        `long:"namespace" short:"n" env:"NAMESPACE" description:"The namespace where the configmap is deployed"`
-	ConfigMapName string        `long:"config-map-name" short:"m" env:"CONFIG_MAP_NAME" default:"namespace-node-affinity" description:"Name of the configm map containing the node selector terms to be applied to every pod on creation."`
-	KubeConfig    string        `long:"kubeconfig" default:"" description:"Path to a kubeconfig file, if running external to a kubernets cluster for testing"`
+	Port            int           `long:"port" short:"p" env:"PORT" default:"8443" description:"The port on which to serve."`
+	ReadTimeout     time.Duration `long:"read-timeout" default:"10s" description:"Read timeout"`
+	WriteTimeout    time.Duration `long:"write-timeout" default:"10s" description:"Write timeout"`
+	CertFile        string        `lond:"cert" short:"c" env:"CERT" default:"/etc/webhook/certs/tls.crt" description:"Path to the cert file"`
+	KeyFile         string        `lond:"key" short:"k" env:"KEY" default:"/etc/webhook/certs/tls.key" description:"Path to the key file"`
+	Namespace       string        `long:"namespace" short:"n" env:"NAMESPACE" description:"The namespace where the configmap is deployed"`
+	ConfigMapName   string        `long:"config-map-name" short:"m" env:"CONFIG_MAP_NAME" default:"namespace-node-affinity" description:"Name of the configm map containing the node selector terms to be applied to every pod on creation."`
+	KubeConfig      string        `long:"kubeconfig" default:"" description:"Path to a kubeconfig file, if running external to a kubernets cluster for testing"`
+	EnablePolicies  bool          `long:"enable-policies" env:"ENABLE_POLICIES" description:"Read the configuration from NamespaceAffinityPolicy objects in addition to the config map. Requires the NamespaceAffinityPolicy CRD."`
+	MutateWorkloads bool          `long:"mutate-workloads" env:"MUTATE_WORKLOADS" description:"Also patch the pod templates of Deployments, StatefulSets, DaemonSets, ReplicaSets, Jobs and CronJobs. The webhook needs to be registered for them."`
+	CheckNodes      bool          `long:"check-nodes" env:"CHECK_NODES" description:"Warn about the configurations whose required terms match no schedulable node, or only nodes with taints which are not tolerated."`
 }
 
 type injectorInterface interface {
 	Mutate(body []byte) ([]byte, error)
+	Validate(body []byte) ([]byte, error)
 }
 
 type handler struct {
@@ -36,6 +41,16 @@ type handler struct {
 }
 
 func (h *handler) mutate(w http.ResponseWriter, r *http.Request) {
+	review(w, r, h.injector.Mutate)
+}
+
+func (h *handler) validate(w http.ResponseWriter, r *http.Request) {
+	review(w, r, h.injector.Validate)
+}
+
+// review reads the AdmissionReview from r and writes the AdmissionReview
+// returned by reviewFunc to w
+func review(w http.ResponseWriter, r *http.Request, reviewFunc func(body []byte) ([]byte, error)) {
 	body, err := ioutil.ReadAll(r.Body)
 	defer r.Body.Close()
 
@@ -45,7 +60,7 @@ func (h *handler) mutate(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "%s", err)
 	}
 
-	mutated, err := h.injector.Mutate(body)
+	reviewed, err := reviewFunc(body)
 	if err != nil {
 		log.Error(err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -53,7 +68,7 @@ func (h *handler) mutate(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.WriteHeader(http.StatusOK)
-	w.Write(mutated)
+	w.Write(reviewed)
 }
 
 func main() {
@@ -82,10 +97,36 @@ func main() {
 		log.Fatalf("Failed to create k8s client: %s", err)
 	}
 
-	h := handler{
-		injector.NewInjector(clientset, opts.Namespace, opts.ConfigMapName),
+	var injectorOpts []injector.Option
+
+	if opts.EnablePolicies {
+		dynamicClient, err := dynamic.NewForConfig(config)
+		if err != nil {
+			log.Fatalf("Failed to create k8s dynamic client: %s", err)
+		}
+		injectorOpts = append(injectorOpts, injector.WithPolicies(dynamicClient))
+	}
+
+	if opts.MutateWorkloads {
+		injectorOpts = append(injectorOpts, injector.WithWorkloads())
+	}
+
+	if opts.CheckNodes {
+		injectorOpts = append(injectorOpts, injector.WithNodeChecks())
+	}
+
+	inj := injector.NewInjector(clientset, opts.Namespace, opts.ConfigMapName, injectorOpts...)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	if err := inj.Start(stopCh); err != nil {
+		log.Fatalf("Failed to start the injector: %s", err)
 	}
+
+	h := handler{inj}
 	mux.HandleFunc("/mutate", h.mutate)
+	mux.HandleFunc("/validate", h.validate)
 
 	mux.Handle("/metrics", promhttp.Handler())
 
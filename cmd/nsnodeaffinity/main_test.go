@@ -11,8 +11,9 @@ import (
 )
 
 var (
-	readerErr = "reader error"
-	mutateErr = "mutate error"
+	readerErr   = "reader error"
+	mutateErr   = "mutate error"
+	validateErr = "validate error"
 )
 
 type FakeInjector struct {
@@ -24,6 +25,10 @@ func (f *FakeInjector) Mutate(body []byte) ([]byte, error) {
 	return f.body, f.err
 }
 
+func (f *FakeInjector) Validate(body []byte) ([]byte, error) {
+	return f.body, f.err
+}
+
 type errReader struct {
 }
 
@@ -84,3 +89,57 @@ func TestMutate(t *testing.T) {
 	assert.Equal(t, http.StatusOK, rec.Code)
 	assert.Equal(t, "test", rec.Body.String())
 }
+
+func TestValidateWithRequestError(t *testing.T) {
+	t.Parallel()
+
+	h := handler{
+		injector: &FakeInjector{},
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/validate", errReader{})
+	rec := httptest.NewRecorder()
+
+	h.validate(rec, req)
+
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, readerErr, rec.Body.String())
+}
+
+func TestValidateWithValidatorError(t *testing.T) {
+	t.Parallel()
+
+	h := handler{
+		injector: &FakeInjector{
+			err: errors.New(validateErr),
+		},
+	}
+
+	rdr := strings.NewReader("testing")
+	req := httptest.NewRequest(http.MethodPost, "/validate", rdr)
+	rec := httptest.NewRecorder()
+
+	h.validate(rec, req)
+
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, validateErr, rec.Body.String())
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	h := handler{
+		injector: &FakeInjector{
+			body: []byte("test"),
+		},
+	}
+
+	rdr := strings.NewReader("testing")
+	req := httptest.NewRequest(http.MethodPost, "/validate", rdr)
+	rec := httptest.NewRecorder()
+
+	h.validate(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "test", rec.Body.String())
+}
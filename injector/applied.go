@@ -0,0 +1,75 @@
+package injector
+
+import (
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// appliedConfigAnnotationKey is the pod annotation describing the
+// configuration applied to the pod, so users can tell where the changes to
+// their pods come from without access to the audit log
+const appliedConfigAnnotationKey = "namespace-node-affinity.idgenchev.github.com/applied-config"
+
+// appliedConfig is the value of the applied config annotation: the entry the
+// configuration comes from, the names of the rules matching the pod and the
+// hash of the configuration (see configHash)
+type appliedConfig struct {
+	configSource
+	Rules []string `json:"rules,omitempty"`
+	Hash  string   `json:"hash"`
+}
+
+// ruleNames returns the names of the rules of config matching a pod with
+// podLabels. Unnamed rules are left out
+func ruleNames(config *NamespaceConfig, podLabels map[string]string) ([]string, error) {
+	rules, err := matchRules(config, podLabels)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, rule := range rules {
+		if rule.Name != "" {
+			names = append(names, rule.Name)
+		}
+	}
+
+	return names, nil
+}
+
+// buildConfigAnnotationsPatches returns the patches setting the config hash
+// and the applied config annotations of pod
+func buildConfigAnnotationsPatches(pod *corev1.Pod, applied *appliedConfig) ([]JSONPatch, error) {
+	value, err := jsonMarshal(applied)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrFailedToCreatePatch, err)
+	}
+
+	annotations := map[string]string{
+		configHashAnnotationKey:    applied.Hash,
+		appliedConfigAnnotationKey: string(value),
+	}
+
+	if pod.Annotations == nil {
+		return []JSONPatch{{Op: "add", Path: CreateAnnotations, Value: annotations}}, nil
+	}
+
+	keys := make([]string, 0, len(annotations))
+	for key := range annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	patches := make([]JSONPatch, 0, len(keys))
+	for _, key := range keys {
+		patches = append(patches, JSONPatch{
+			Op:    "add",
+			Path:  PatchPath(AddAnnotation + jsonPointerEscaper.Replace(key)),
+			Value: annotations[key],
+		})
+	}
+
+	return patches, nil
+}
@@ -0,0 +1,191 @@
+package injector
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestBuildConfigAnnotationsPatches(t *testing.T) {
+	t.Parallel()
+
+	applied := &appliedConfig{
+		configSource: configSource{Kind: configMapSourceKind, Entry: "testing-ns"},
+		Rules:        []string{"gpu"},
+		Hash:         "hash",
+	}
+	appliedValue := `{"source":"ConfigMap","entry":"testing-ns","rules":["gpu"],"hash":"hash"}`
+
+	testCases := []struct {
+		name            string
+		pod             *corev1.Pod
+		expectedPatches []JSONPatch
+	}{
+		{
+			name: "NoAnnotations",
+			pod:  &corev1.Pod{},
+			expectedPatches: []JSONPatch{
+				{
+					Op:   "add",
+					Path: CreateAnnotations,
+					Value: map[string]string{
+						configHashAnnotationKey:    "hash",
+						appliedConfigAnnotationKey: appliedValue,
+					},
+				},
+			},
+		},
+		{
+			name: "ExistingAnnotations",
+			pod: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{configHashAnnotationKey: "stale"},
+				},
+			},
+			expectedPatches: []JSONPatch{
+				{
+					Op:    "add",
+					Path:  "/metadata/annotations/namespace-node-affinity.idgenchev.github.com~1applied-config",
+					Value: appliedValue,
+				},
+				{
+					Op:    "add",
+					Path:  "/metadata/annotations/namespace-node-affinity.idgenchev.github.com~1config-hash",
+					Value: "hash",
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			patches, err := buildConfigAnnotationsPatches(tc.pod, applied)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedPatches, patches)
+		})
+	}
+}
+
+func TestConfigSource(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name           string
+		namespace      string
+		data           map[string]string
+		expectedSource configSource
+	}{
+		{
+			name:      "NamespaceEntry",
+			namespace: "testing-ns",
+			data: map[string]string{
+				"testing-ns":     "tolerations: [{key: exact, operator: Exists}]",
+				defaultConfigKey: "tolerations: [{key: default, operator: Exists}]",
+			},
+			expectedSource: configSource{Kind: configMapSourceKind, Entry: "testing-ns"},
+		},
+		{
+			name:      "PatternEntry",
+			namespace: "ci-build",
+			data: map[string]string{
+				patternsConfigKey: "ci-*: {tolerations: [{key: ci, operator: Exists}]}",
+			},
+			expectedSource: configSource{Kind: configMapSourceKind, Entry: "_patterns/ci-*"},
+		},
+		{
+			name:      "SelectedEntry",
+			namespace: "testing-ns",
+			data: map[string]string{
+				"data-pool": "{namespaceSelector: {matchLabels: {team: data}}, tolerations: [{key: data, operator: Exists}]}",
+			},
+			expectedSource: configSource{Kind: configMapSourceKind, Entry: "data-pool"},
+		},
+		{
+			name:      "DefaultEntry",
+			namespace: "testing-ns",
+			data: map[string]string{
+				defaultConfigKey: "tolerations: [{key: default, operator: Exists}]",
+			},
+			expectedSource: configSource{Kind: configMapSourceKind, Entry: defaultConfigKey},
+		},
+		{
+			name:      "MergedDefault",
+			namespace: "testing-ns",
+			data: map[string]string{
+				"testing-ns":     "{mergeDefault: true, tolerations: [{key: exact, operator: Exists}]}",
+				defaultConfigKey: "tolerations: [{key: default, operator: Exists}]",
+			},
+			expectedSource: configSource{Kind: configMapSourceKind, Entry: "testing-ns", MergedDefault: true},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ns := namespace(tc.namespace, map[string]string{"team": "data"})
+			m := newTestInjectorWithConfig(t, tc.data, ns)
+
+			_, source, err := m.configForNamespace(tc.namespace)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedSource, source)
+		})
+	}
+}
+
+func TestMutateRecordsAppliedConfig(t *testing.T) {
+	t.Parallel()
+
+	m := newTestInjectorWithConfig(t, map[string]string{
+		"testing-ns": `
+ruleMatching: all
+rules:
+  - name: gpu
+    selector: {matchLabels: {gpu: "true"}}
+    tolerations: [{key: gpu, operator: Exists}]
+  - selector: {matchLabels: {gpu: "true"}}
+    tolerations: [{key: unnamed, operator: Exists}]
+  - name: spot
+    selector: {matchLabels: {spot: "true"}}
+    tolerations: [{key: spot, operator: Exists}]
+`,
+	})
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"gpu": "true"}},
+	}
+	admissionReview := admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			Namespace: "testing-ns",
+			Object:    runtime.RawExtension{Object: pod},
+		},
+	}
+	j, err := json.Marshal(admissionReview)
+	assert.NoError(t, err)
+
+	body, err := m.Mutate(j)
+	assert.NoError(t, err)
+
+	resp := admissionv1.AdmissionReview{}
+	assert.NoError(t, json.Unmarshal(body, &resp))
+
+	var patches []JSONPatch
+	assert.NoError(t, json.Unmarshal(resp.Response.Patch, &patches))
+	annotations := patches[len(patches)-1].Value.(map[string]interface{})
+
+	applied := appliedConfig{}
+	assert.NoError(t, json.Unmarshal([]byte(annotations[appliedConfigAnnotationKey].(string)), &applied))
+	assert.Equal(t, configSource{Kind: configMapSourceKind, Entry: "testing-ns"}, applied.configSource)
+	assert.Equal(t, []string{"gpu"}, applied.Rules)
+	assert.Equal(t, annotations[configHashAnnotationKey], applied.Hash)
+}
@@ -0,0 +1,35 @@
+package injector
+
+import (
+	"fmt"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// auditAnnotationKey is the audit annotation holding the patch which would
+// have been applied to a pod in a namespace in audit mode
+const auditAnnotationKey = "namespace-node-affinity.idgenchev.github.com/audit-patch"
+
+// auditResponse turns resp into the response for a namespace in audit mode.
+// The pod is admitted unmodified and the denial or the would-be patch of
+// resp are reported in the warnings and the audit annotations instead
+func auditResponse(resp *admissionv1.AdmissionResponse, namespace string) {
+	if !resp.Allowed {
+		resp.Warnings = append(resp.Warnings, fmt.Sprintf("namespace %s is in audit mode, the pod would be denied: %s", namespace, resp.Result.Message))
+		resp.Allowed = true
+		resp.Result = &metav1.Status{Status: successStatus}
+		return
+	}
+
+	if resp.Patch == nil {
+		return
+	}
+
+	resp.Warnings = append(resp.Warnings, fmt.Sprintf("namespace %s is in audit mode, the pod would be patched with: %s", namespace, resp.Patch))
+	resp.AuditAnnotations = map[string]string{
+		auditAnnotationKey: string(resp.Patch),
+	}
+	resp.Patch = nil
+	resp.PatchType = nil
+}
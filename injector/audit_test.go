@@ -0,0 +1,136 @@
+package injector
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	v1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestAuditResponse(t *testing.T) {
+	t.Parallel()
+
+	jsonPatch := admissionv1.PatchTypeJSONPatch
+
+	testCases := []struct {
+		name         string
+		resp         *admissionv1.AdmissionResponse
+		expectedResp *admissionv1.AdmissionResponse
+	}{
+		{
+			name: "Patch",
+			resp: &admissionv1.AdmissionResponse{
+				Allowed:          true,
+				Patch:            []byte(`[{"op":"add"}]`),
+				PatchType:        &jsonPatch,
+				AuditAnnotations: map[string]string{annotationKey: `[{"op":"add"}]`},
+				Result:           &metav1.Status{Status: successStatus},
+			},
+			expectedResp: &admissionv1.AdmissionResponse{
+				Allowed:          true,
+				AuditAnnotations: map[string]string{auditAnnotationKey: `[{"op":"add"}]`},
+				Warnings:         []string{`namespace testing-ns is in audit mode, the pod would be patched with: [{"op":"add"}]`},
+				Result:           &metav1.Status{Status: successStatus},
+			},
+		},
+		{
+			name: "Denied",
+			resp: &admissionv1.AdmissionResponse{
+				Result: &metav1.Status{Message: "denied", Code: http.StatusForbidden},
+			},
+			expectedResp: &admissionv1.AdmissionResponse{
+				Allowed:  true,
+				Warnings: []string{"namespace testing-ns is in audit mode, the pod would be denied: denied"},
+				Result:   &metav1.Status{Status: successStatus},
+			},
+		},
+		{
+			name: "NoPatch",
+			resp: &admissionv1.AdmissionResponse{
+				Allowed: true,
+				Result:  &metav1.Status{Status: successStatus},
+			},
+			expectedResp: &admissionv1.AdmissionResponse{
+				Allowed: true,
+				Result:  &metav1.Status{Status: successStatus},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			auditResponse(tc.resp, "testing-ns")
+			assert.Equal(t, tc.expectedResp, tc.resp)
+		})
+	}
+}
+
+func TestMutateInAuditMode(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name             string
+		config           string
+		expectedWarnings []string
+		expectedPatch    string
+	}{
+		{
+			name:          "Patch",
+			config:        "{mode: audit, nodeSelector: {pool: a}}",
+			expectedPatch: `[{"op":"add","path":"/spec/nodeSelector","value":{"pool":"a"}}]`,
+			expectedWarnings: []string{
+				`namespace testing-ns is in audit mode, the pod would be patched with: [{"op":"add","path":"/spec/nodeSelector","value":{"pool":"a"}}]`,
+			},
+		},
+		{
+			name:   "Reject",
+			config: "{mode: audit, conflictStrategy: reject, nodeSelectorTerms: [{matchExpressions: [{key: pool, operator: In, values: [a]}]}]}",
+			expectedWarnings: []string{
+				"namespace testing-ns is in audit mode, the pod would be denied: " + conflictStatus("testing-ns").Message,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := newTestInjectorWithConfig(t, map[string]string{"testing-ns": tc.config})
+
+			admissionReview := v1beta1.AdmissionReview{
+				Request: &v1beta1.AdmissionRequest{
+					Namespace: "testing-ns",
+					Object: runtime.RawExtension{
+						Object: &corev1.Pod{Spec: podSpecWithExistingNodeSelectorTerms},
+					},
+				},
+			}
+			j, err := json.Marshal(admissionReview)
+			assert.NoError(t, err)
+
+			body, err := m.Mutate(j)
+			assert.NoError(t, err)
+
+			resp := v1beta1.AdmissionReview{}
+			assert.NoError(t, json.Unmarshal(body, &resp))
+			assert.True(t, resp.Response.Allowed)
+			assert.Nil(t, resp.Response.Patch)
+			assert.Nil(t, resp.Response.PatchType)
+			assert.Equal(t, tc.expectedWarnings, resp.Response.Warnings)
+
+			if tc.expectedPatch != "" {
+				assert.JSONEq(t, tc.expectedPatch, resp.Response.AuditAnnotations[auditAnnotationKey])
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package injector
+
+import (
+	"sync"
+)
+
+type cachedValue[T any] struct {
+	resourceVersion string
+	value           T
+}
+
+// configCache holds values parsed from the config (eg: *NamespaceConfig)
+// keyed by the name of the entry they were parsed from. A value is only
+// returned for the resourceVersion of the object it was parsed from, so every
+// entry is parsed at most once per change of its source object.
+//
+// The cached values are shared between requests and must be treated as
+// read-only.
+type configCache[T any] struct {
+	mu     sync.Mutex
+	values map[string]cachedValue[T]
+}
+
+func newConfigCache[T any]() *configCache[T] {
+	return &configCache[T]{values: map[string]cachedValue[T]{}}
+}
+
+// get returns the cached value for key if it was parsed from resourceVersion
+func (c *configCache[T]) get(key, resourceVersion string) (T, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.values[key]
+	if !ok || cached.resourceVersion != resourceVersion {
+		var zero T
+		return zero, false
+	}
+
+	return cached.value, true
+}
+
+// set stores value parsed from resourceVersion for key, replacing the value
+// parsed from any other resourceVersion
+func (c *configCache[T]) set(key, resourceVersion string, value T) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.values[key] = cachedValue[T]{resourceVersion, value}
+}
+
+// delete drops the cached value for key
+func (c *configCache[T]) delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.values, key)
+}
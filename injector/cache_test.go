@@ -0,0 +1,70 @@
+package injector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestConfigCache(t *testing.T) {
+	t.Parallel()
+
+	c := newConfigCache[*NamespaceConfig]()
+	config := &NamespaceConfig{Tolerations: tolerations()}
+
+	_, ok := c.get("ns", "1")
+	assert.False(t, ok)
+
+	c.set("ns", "1", config)
+	cached, ok := c.get("ns", "1")
+	assert.True(t, ok)
+	assert.Same(t, config, cached)
+
+	_, ok = c.get("ns", "2")
+	assert.False(t, ok, "entries from another resourceVersion should not be returned")
+
+	c.delete("ns")
+	_, ok = c.get("ns", "1")
+	assert.False(t, ok)
+}
+
+func TestConfigForNamespaceReparsesOnlyOnChange(t *testing.T) {
+	t.Parallel()
+
+	deploymentNamespace := "ns-node-affinity"
+	podNamespace := "testing-ns"
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "test-cm",
+			Namespace:       deploymentNamespace,
+			ResourceVersion: "1",
+		},
+		Data: map[string]string{podNamespace: "tolerations: [{key: a, operator: Exists}]"},
+	}
+	clientset := fake.NewSimpleClientset(cm)
+	m := newTestInjector(t, clientset, deploymentNamespace, "test-cm")
+
+	first, _, err := m.configForNamespace(podNamespace)
+	assert.NoError(t, err)
+
+	second, _, err := m.configForNamespace(podNamespace)
+	assert.NoError(t, err)
+	assert.Same(t, first, second)
+
+	updated := cm.DeepCopy()
+	updated.ResourceVersion = "2"
+	updated.Data[podNamespace] = "tolerations: [{key: b, operator: Exists}]"
+	_, err = clientset.CoreV1().ConfigMaps(deploymentNamespace).Update(context.Background(), updated, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		config, _, err := m.configForNamespace(podNamespace)
+		return err == nil && config.Tolerations[0].Key == "b"
+	}, 5*time.Second, 10*time.Millisecond)
+}
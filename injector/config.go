@@ -0,0 +1,704 @@
+package injector
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/idgenchev/namespace-node-affinity/api/v1alpha1"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// defaultConfigKey is the reserved ConfigMap key holding the
+	// configuration for enabled namespaces without an entry of their own
+	defaultConfigKey = "_default"
+	// reservedKeyPrefix is the prefix of the ConfigMap keys which are not
+	// entries for a namespace. Namespace names cannot start with it
+	reservedKeyPrefix = "_"
+	// patternsConfigKey is the reserved ConfigMap key holding the entries
+	// keyed by a glob or a regular expression matched against the namespace
+	// name. ConfigMap keys cannot hold the special characters of the patterns
+	patternsConfigKey = "_patterns"
+	// regexpKeyPrefix is the prefix of the pattern keys holding a regular
+	// expression
+	regexpKeyPrefix = "re:"
+	// profilesConfigKey is the reserved ConfigMap key holding the named
+	// profiles referenced by the "use" of the entries
+	profilesConfigKey = "_profiles"
+)
+
+// Kinds of the objects holding the config for a namespace
+const (
+	policySourceKind    = "NamespaceAffinityPolicy"
+	configMapSourceKind = "ConfigMap"
+)
+
+// configSource identifies the entry the config for a namespace comes from
+type configSource struct {
+	// Kind is the kind of the object holding the entry
+	Kind string `json:"source"`
+	// Entry is the name of the NamespaceAffinityPolicy or the key of the
+	// ConfigMap entry. The keys of the "_patterns" entries are prefixed
+	// with "_patterns/"
+	Entry string `json:"entry"`
+	// MergedDefault reports whether the "_default" entry is merged into the
+	// entry
+	MergedDefault bool `json:"mergedDefault,omitempty"`
+}
+
+// selectorEntry is an entry with a namespaceSelector matching a namespace
+type selectorEntry struct {
+	name         string
+	fromPolicy   bool
+	requirements int
+	config       *NamespaceConfig
+}
+
+// configForNamespace returns the NamespaceConfig for namespace from the
+// informer caches and the entry it comes from. The config is looked up in the
+// following order:
+//   - the NamespaceAffinityPolicy with the same name as the namespace
+//   - the ConfigMap entry with the same name as the namespace
+//   - the "_patterns" entries with a glob or a regular expression key
+//     matching the name of the namespace (see patternEntry for their
+//     precedence)
+//   - the entries with a namespaceSelector matching the labels of the
+//     namespace (see selectedEntry for their precedence)
+//   - the "_default" entry of the ConfigMap
+//
+// The profiles referenced by the entry are composed with it (see
+// resolveProfiles). The parsed config is reused until the resourceVersion of its source object
+// changes
+func (m *Injector) configForNamespace(namespace string) (*NamespaceConfig, configSource, error) {
+	config, source, err := m.namespaceEntry(namespace)
+	if errors.Is(err, ErrMissingConfiguration) {
+		matched, matchedSource, matchErr := m.patternEntry(namespace)
+		if matchErr == nil && matched == nil {
+			matched, matchedSource, matchErr = m.selectedEntry(namespace)
+		}
+
+		if matchErr != nil {
+			return nil, configSource{}, matchErr
+		} else if matched != nil {
+			config, source, err = matched, matchedSource, nil
+		}
+	}
+
+	if err != nil && !errors.Is(err, ErrMissingConfiguration) {
+		return nil, configSource{}, err
+	}
+
+	if config == nil || config.MergeDefault == nil || *config.MergeDefault {
+		defaultConfig, defaultErr := m.configMapEntry(defaultConfigKey)
+		if defaultErr != nil && !errors.Is(defaultErr, ErrMissingConfiguration) {
+			return nil, configSource{}, defaultErr
+		}
+
+		if config == nil && defaultConfig == nil {
+			return nil, configSource{}, err
+		} else if config == nil {
+			config = defaultConfig
+			source = configSource{Kind: configMapSourceKind, Entry: defaultConfigKey}
+		} else if defaultConfig != nil && mergeDefault(config, defaultConfig) {
+			if defaultConfig, err = m.resolveProfiles(defaultConfig); err != nil {
+				return nil, configSource{}, err
+			}
+			if config, err = m.resolveProfiles(config); err != nil {
+				return nil, configSource{}, err
+			}
+			config = mergeNamespaceConfigs(defaultConfig, config)
+			source.MergedDefault = true
+		}
+	}
+
+	config, err = m.resolveProfiles(config)
+	if err != nil {
+		return nil, configSource{}, err
+	}
+
+	if err := validateNamespaceConfig(namespace, config); err != nil {
+		return nil, configSource{}, err
+	}
+
+	return config, source, nil
+}
+
+// namespaceEntry returns the NamespaceAffinityPolicy or the ConfigMap entry
+// for namespace. Entries with a namespaceSelector are not matched by name
+func (m *Injector) namespaceEntry(namespace string) (*NamespaceConfig, configSource, error) {
+	if m.policyLister != nil {
+		config, found, err := m.policyForNamespace(namespace)
+		if err != nil {
+			return nil, configSource{}, err
+		} else if found && config.NamespaceSelector == nil {
+			return config, configSource{Kind: policySourceKind, Entry: namespace}, nil
+		}
+	}
+
+	config, err := m.configMapEntry(namespace)
+	if err == nil && config.NamespaceSelector != nil {
+		return nil, configSource{}, fmt.Errorf("%w: for %s", ErrMissingConfiguration, namespace)
+	}
+
+	return config, configSource{Kind: configMapSourceKind, Entry: namespace}, err
+}
+
+// patternEntry returns the entry of the "_patterns" ConfigMap entry with a
+// glob (eg: "ci-*") or a regular expression (eg: "re:^preview-pr-\d+$") key
+// matching namespace or nil if there is no such entry. When several keys
+// match, the longest pattern (without the "re:" prefix) wins and ties are
+// broken by the key
+func (m *Injector) patternEntry(namespace string) (*NamespaceConfig, configSource, error) {
+	configMap, err := m.configMapLister.Get(m.configMapName)
+	if err != nil {
+		return nil, configSource{}, nil
+	}
+
+	patterns, err := m.parsePatterns(configMap)
+	if err != nil {
+		return nil, configSource{}, err
+	}
+
+	var keys []string
+	for key := range patterns {
+		if m.matchesPattern(key, namespace) {
+			keys = append(keys, key)
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, configSource{}, nil
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := strings.TrimPrefix(keys[i], regexpKeyPrefix), strings.TrimPrefix(keys[j], regexpKeyPrefix)
+		if len(a) != len(b) {
+			return len(a) > len(b)
+		}
+		return keys[i] < keys[j]
+	})
+
+	log.Debugf("Using pattern %s for namespace %s", keys[0], namespace)
+
+	return patterns[keys[0]], configSource{Kind: configMapSourceKind, Entry: patternsConfigKey + "/" + keys[0]}, nil
+}
+
+// parsePatterns returns the parsed "_patterns" entry of configMap
+func (m *Injector) parsePatterns(configMap *corev1.ConfigMap) (map[string]*NamespaceConfig, error) {
+	patterns, err := m.parseEntries(configMap, patternsConfigKey)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, config := range patterns {
+		if config.NamespaceSelector != nil {
+			return nil, fmt.Errorf("%w: %s: namespaceSelector is not supported for %s", ErrInvalidConfiguration, patternsConfigKey, key)
+		}
+	}
+
+	return patterns, nil
+}
+
+// parseEntries returns the parsed map of entries in the reserved key of
+// configMap or nil if there is no such key
+func (m *Injector) parseEntries(configMap *corev1.ConfigMap, key string) (map[string]*NamespaceConfig, error) {
+	entriesString, exists := configMap.Data[key]
+	if !exists {
+		return nil, nil
+	}
+
+	if entries, ok := m.entriesCache.get(key, configMap.ResourceVersion); ok {
+		return entries, nil
+	}
+
+	entries := map[string]*NamespaceConfig{}
+	if err := yamlUnmarshal([]byte(entriesString), &entries); err != nil {
+		return nil, fmt.Errorf("%w: %s: %s", ErrInvalidConfiguration, key, err)
+	}
+
+	for name, config := range entries {
+		if config == nil {
+			return nil, fmt.Errorf("%w: %s: empty entry for %s", ErrInvalidConfiguration, key, name)
+		}
+	}
+
+	m.entriesCache.set(key, configMap.ResourceVersion, entries)
+
+	return entries, nil
+}
+
+// resolveProfiles returns config composed with the profiles it uses. The
+// profiles are composed in the order they are listed in "use", each profile
+// after the profiles it uses itself, followed by config. Missing profiles and
+// reference cycles are reported as ErrInvalidConfiguration
+func (m *Injector) resolveProfiles(config *NamespaceConfig) (*NamespaceConfig, error) {
+	if len(config.Use) == 0 {
+		return config, nil
+	}
+
+	var profiles map[string]*NamespaceConfig
+	if configMap, err := m.configMapLister.Get(m.configMapName); err == nil {
+		if profiles, err = m.parseEntries(configMap, profilesConfigKey); err != nil {
+			return nil, err
+		}
+	}
+
+	return composeProfiles(config, profiles, nil)
+}
+
+// composeProfiles composes config with the profiles it uses. path holds the
+// names of the profiles being composed to detect reference cycles
+func composeProfiles(config *NamespaceConfig, profiles map[string]*NamespaceConfig, path []string) (*NamespaceConfig, error) {
+	composed := &NamespaceConfig{}
+
+	for _, name := range config.Use {
+		for _, parent := range path {
+			if parent == name {
+				return nil, fmt.Errorf("%w: profile reference cycle: %s -> %s", ErrInvalidConfiguration, strings.Join(path, " -> "), name)
+			}
+		}
+
+		profile, ok := profiles[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: missing profile %s", ErrInvalidConfiguration, name)
+		}
+
+		profilePath := append(append([]string{}, path...), name)
+		resolved, err := composeProfiles(profile, profiles, profilePath)
+		if err != nil {
+			return nil, err
+		}
+
+		composed = mergeNamespaceConfigs(composed, resolved)
+	}
+
+	return mergeNamespaceConfigs(composed, config), nil
+}
+
+// matchesPattern reports whether the glob or the regular expression in key
+// matches namespace
+func (m *Injector) matchesPattern(key, namespace string) bool {
+	if strings.HasPrefix(key, regexpKeyPrefix) {
+		re, err := m.compileRegexp(strings.TrimPrefix(key, regexpKeyPrefix))
+		if err != nil {
+			log.Warningf("Skipping pattern %s with invalid regular expression: %s", key, err)
+			return false
+		}
+		return re.MatchString(namespace)
+	}
+
+	matched, err := path.Match(key, namespace)
+	if err != nil {
+		log.Warningf("Skipping pattern %s with invalid glob: %s", key, err)
+	}
+	return matched
+}
+
+// compileRegexp returns the compiled expr, reusing previously compiled
+// expressions
+func (m *Injector) compileRegexp(expr string) (*regexp.Regexp, error) {
+	if re, ok := m.regexps.Load(expr); ok {
+		return re.(*regexp.Regexp), nil
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	m.regexps.Store(expr, re)
+
+	return re, nil
+}
+
+// selectedEntry returns the entry with a namespaceSelector matching the labels
+// of namespace or nil if there is no such entry. When several entries match,
+// the one with the most requirements (matchLabels and matchExpressions) in its
+// namespaceSelector wins. Ties are broken in favour of the
+// NamespaceAffinityPolicies and then by the name of the entry. Entries which
+// cannot be parsed are skipped
+func (m *Injector) selectedEntry(namespace string) (*NamespaceConfig, configSource, error) {
+	ns, err := m.namespaceLister.Get(namespace)
+	if k8serrors.IsNotFound(err) {
+		return nil, configSource{}, nil
+	} else if err != nil {
+		return nil, configSource{}, fmt.Errorf("%w: %s", ErrMissingConfiguration, err)
+	}
+
+	var entries []selectorEntry
+
+	if m.policyLister != nil {
+		objs, err := m.policyLister.List(labels.Everything())
+		if err != nil {
+			return nil, configSource{}, fmt.Errorf("%w: %s", ErrMissingConfiguration, err)
+		}
+
+		for _, obj := range objs {
+			u, ok := obj.(*unstructured.Unstructured)
+			if !ok {
+				continue
+			}
+
+			config, err := m.policyConfig(u)
+			if err != nil {
+				log.Warningf("Skipping NamespaceAffinityPolicy %s: %s", u.GetName(), err)
+				continue
+			}
+
+			if entry, ok := selectEntry(ns, u.GetName(), config); ok {
+				entry.fromPolicy = true
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	if configMap, err := m.configMapLister.Get(m.configMapName); err == nil {
+		for key := range configMap.Data {
+			if strings.HasPrefix(key, reservedKeyPrefix) {
+				continue
+			}
+
+			config, err := m.parseConfigMapEntry(configMap, key)
+			if err != nil {
+				log.Warningf("Skipping entry %s: %s", key, err)
+				continue
+			}
+
+			if entry, ok := selectEntry(ns, key, config); ok {
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	if len(entries) == 0 {
+		return nil, configSource{}, nil
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.requirements != b.requirements {
+			return a.requirements > b.requirements
+		} else if a.fromPolicy != b.fromPolicy {
+			return a.fromPolicy
+		}
+		return a.name < b.name
+	})
+
+	log.Debugf("Using entry %s for namespace %s", entries[0].name, namespace)
+
+	source := configSource{Kind: configMapSourceKind, Entry: entries[0].name}
+	if entries[0].fromPolicy {
+		source.Kind = policySourceKind
+	}
+
+	return entries[0].config, source, nil
+}
+
+// selectEntry returns a selectorEntry for config if its namespaceSelector
+// matches the labels of ns
+func selectEntry(ns *corev1.Namespace, name string, config *NamespaceConfig) (selectorEntry, bool) {
+	if config.NamespaceSelector == nil {
+		return selectorEntry{}, false
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(config.NamespaceSelector)
+	if err != nil {
+		log.Warningf("Skipping entry %s with invalid namespaceSelector: %s", name, err)
+		return selectorEntry{}, false
+	}
+
+	if !selector.Matches(labels.Set(ns.Labels)) {
+		return selectorEntry{}, false
+	}
+
+	return selectorEntry{
+		name:         name,
+		requirements: len(config.NamespaceSelector.MatchLabels) + len(config.NamespaceSelector.MatchExpressions),
+		config:       config,
+	}, true
+}
+
+// configMapEntry returns the parsed ConfigMap entry for key or
+// ErrMissingConfiguration when there is no such entry
+func (m *Injector) configMapEntry(key string) (*NamespaceConfig, error) {
+	configMap, err := m.configMapLister.Get(m.configMapName)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrMissingConfiguration, err)
+	}
+
+	return m.parseConfigMapEntry(configMap, key)
+}
+
+// parseConfigMapEntry returns the parsed entry for key of configMap
+func (m *Injector) parseConfigMapEntry(configMap *corev1.ConfigMap, key string) (*NamespaceConfig, error) {
+	namespaceConfigString, exists := configMap.Data[key]
+	if !exists {
+		return nil, fmt.Errorf("%w: for %s", ErrMissingConfiguration, key)
+	}
+
+	if config, ok := m.configCache.get(key, configMap.ResourceVersion); ok {
+		return config, nil
+	}
+
+	config, err := parseNamespaceConfig(namespaceConfigString)
+	if err != nil {
+		return nil, err
+	}
+
+	m.configCache.set(key, configMap.ResourceVersion, config)
+
+	return config, nil
+}
+
+func parseNamespaceConfig(namespaceConfigString string) (*NamespaceConfig, error) {
+	config := &NamespaceConfig{}
+	err := yamlUnmarshal([]byte(namespaceConfigString), config)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidConfiguration, err)
+	}
+
+	return config, nil
+}
+
+func validateNamespaceConfig(namespace string, config *NamespaceConfig) error {
+	if config.NodeSelectorTerms == nil && config.PreferredNodeSelectorTerms == nil && config.Tolerations == nil &&
+		config.NodeSelector == nil && config.TopologySpreadConstraints == nil &&
+		config.PodAffinity == nil && config.PodAntiAffinity == nil &&
+		config.PriorityClassName == "" && config.RuntimeClassName == "" && config.SchedulerName == "" && config.Rules == nil {
+		return fmt.Errorf("%w: at least one of nodeSelectorTerms, preferredNodeSelectorTerms, tolerations, nodeSelector, topologySpreadConstraints, podAffinity, podAntiAffinity, priorityClassName, runtimeClassName, schedulerName or rules needs to be specified for %s", ErrInvalidConfiguration, namespace)
+	}
+
+	switch config.NodeSelectorTermsStrategy {
+	case "", v1alpha1.NodeSelectorTermsStrategyAppend, v1alpha1.NodeSelectorTermsStrategyEnforce:
+	default:
+		return fmt.Errorf("%w: invalid nodeSelectorTermsStrategy %q for %s", ErrInvalidConfiguration, config.NodeSelectorTermsStrategy, namespace)
+	}
+
+	switch config.ConflictStrategy {
+	case "", v1alpha1.ConflictStrategyAppend, v1alpha1.ConflictStrategyReplace, v1alpha1.ConflictStrategySkip, v1alpha1.ConflictStrategyReject:
+	default:
+		return fmt.Errorf("%w: invalid conflictStrategy %q for %s", ErrInvalidConfiguration, config.ConflictStrategy, namespace)
+	}
+
+	switch config.NodeSelectorConflictPolicy {
+	case "", v1alpha1.NodeSelectorConflictPolicyReject, v1alpha1.NodeSelectorConflictPolicyReport:
+	default:
+		return fmt.Errorf("%w: invalid nodeSelectorConflictPolicy %q for %s", ErrInvalidConfiguration, config.NodeSelectorConflictPolicy, namespace)
+	}
+
+	switch config.TolerationSecondsPolicy {
+	case "", v1alpha1.TolerationSecondsPolicyKeep, v1alpha1.TolerationSecondsPolicyReplace, v1alpha1.TolerationSecondsPolicyMax, v1alpha1.TolerationSecondsPolicyMin:
+	default:
+		return fmt.Errorf("%w: invalid tolerationSecondsPolicy %q for %s", ErrInvalidConfiguration, config.TolerationSecondsPolicy, namespace)
+	}
+
+	switch config.Mode {
+	case "", v1alpha1.ModeEnforce, v1alpha1.ModeAudit:
+	default:
+		return fmt.Errorf("%w: invalid mode %q for %s", ErrInvalidConfiguration, config.Mode, namespace)
+	}
+
+	if config.ExcludeSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(config.ExcludeSelector); err != nil {
+			return fmt.Errorf("%w: invalid excludeSelector for %s: %s", ErrInvalidConfiguration, namespace, err)
+		}
+	}
+
+	return validateRules(config)
+}
+
+// mergeDefault reports whether config extends defaultConfig. The
+// mergeDefault of the namespace entry takes precedence over the one of the
+// default entry
+func mergeDefault(config, defaultConfig *NamespaceConfig) bool {
+	if config.MergeDefault != nil {
+		return *config.MergeDefault
+	}
+
+	return defaultConfig.MergeDefault != nil && *defaultConfig.MergeDefault
+}
+
+// mergeNamespaceConfigs returns a new NamespaceConfig with the terms (including
+// the podAffinity and podAntiAffinity terms), tolerations and
+// topologySpreadConstraints of override appended to the ones of base and the
+// nodeSelector of override merged into the one of base. The rules of override
+// are evaluated before the ones of base. The exclusions and the strategies of
+// override (ruleMatching, nodeSelectorTermsStrategy, conflictStrategy,
+// tolerationSecondsPolicy, nodeSelectorConflictPolicy and mode) replace the
+// ones of base when set, as do the scheduling fields (priorityClassName,
+// runtimeClassName, schedulerName and override). The fields which only apply to
+// the lookup of the entry (mergeDefault and namespaceSelector) are taken from
+// override and the profiles in "use" are expected to be resolved already
+func mergeNamespaceConfigs(base, override *NamespaceConfig) *NamespaceConfig {
+	merged := &NamespaceConfig{
+		NodeSelectorTerms:          concat(base.NodeSelectorTerms, override.NodeSelectorTerms),
+		PreferredNodeSelectorTerms: concat(base.PreferredNodeSelectorTerms, override.PreferredNodeSelectorTerms),
+		Tolerations:                concat(base.Tolerations, override.Tolerations),
+		ExcludedLabels:             base.ExcludedLabels,
+		ExcludeSelector:            base.ExcludeSelector,
+		ExcludedOwnerKinds:         base.ExcludedOwnerKinds,
+		ExcludedServiceAccounts:    base.ExcludedServiceAccounts,
+		ExcludedAnnotations:        base.ExcludedAnnotations,
+		ExcludeMirrorPods:          base.ExcludeMirrorPods,
+		MergeDefault:               override.MergeDefault,
+		NamespaceSelector:          override.NamespaceSelector,
+		Rules:                      concat(override.Rules, base.Rules),
+		RuleMatching:               base.RuleMatching,
+		NodeSelectorTermsStrategy:  base.NodeSelectorTermsStrategy,
+		ConflictStrategy:           base.ConflictStrategy,
+		TolerationSecondsPolicy:    base.TolerationSecondsPolicy,
+		NodeSelector:               mergeMaps(base.NodeSelector, override.NodeSelector),
+		TopologySpreadConstraints:  concat(base.TopologySpreadConstraints, override.TopologySpreadConstraints),
+		PodAffinity:                mergePodAffinity(base.PodAffinity, override.PodAffinity),
+		PodAntiAffinity:            mergePodAntiAffinity(base.PodAntiAffinity, override.PodAntiAffinity),
+		PriorityClassName:          base.PriorityClassName,
+		RuntimeClassName:           base.RuntimeClassName,
+		SchedulerName:              base.SchedulerName,
+		Override:                   base.Override,
+		NodeSelectorConflictPolicy: base.NodeSelectorConflictPolicy,
+		Mode:                       base.Mode,
+	}
+
+	if override.RuleMatching != "" {
+		merged.RuleMatching = override.RuleMatching
+	}
+
+	if override.NodeSelectorTermsStrategy != "" {
+		merged.NodeSelectorTermsStrategy = override.NodeSelectorTermsStrategy
+	}
+
+	if override.ConflictStrategy != "" {
+		merged.ConflictStrategy = override.ConflictStrategy
+	}
+
+	if override.TolerationSecondsPolicy != "" {
+		merged.TolerationSecondsPolicy = override.TolerationSecondsPolicy
+	}
+
+	if override.NodeSelectorConflictPolicy != "" {
+		merged.NodeSelectorConflictPolicy = override.NodeSelectorConflictPolicy
+	}
+
+	if override.Mode != "" {
+		merged.Mode = override.Mode
+	}
+
+	if override.PriorityClassName != "" {
+		merged.PriorityClassName = override.PriorityClassName
+	}
+
+	if override.RuntimeClassName != "" {
+		merged.RuntimeClassName = override.RuntimeClassName
+	}
+
+	if override.SchedulerName != "" {
+		merged.SchedulerName = override.SchedulerName
+	}
+
+	if override.Override != nil {
+		merged.Override = override.Override
+	}
+
+	if override.ExcludedLabels != nil {
+		merged.ExcludedLabels = override.ExcludedLabels
+	}
+
+	if override.ExcludeSelector != nil {
+		merged.ExcludeSelector = override.ExcludeSelector
+	}
+
+	if override.ExcludedOwnerKinds != nil {
+		merged.ExcludedOwnerKinds = override.ExcludedOwnerKinds
+	}
+
+	if override.ExcludedServiceAccounts != nil {
+		merged.ExcludedServiceAccounts = override.ExcludedServiceAccounts
+	}
+
+	if override.ExcludedAnnotations != nil {
+		merged.ExcludedAnnotations = override.ExcludedAnnotations
+	}
+
+	if override.ExcludeMirrorPods != nil {
+		merged.ExcludeMirrorPods = override.ExcludeMirrorPods
+	}
+
+	return merged
+}
+
+// concat returns a new slice with the elements of a followed by the elements
+// of b or nil if both are nil
+func concat[T any](a, b []T) []T {
+	if a == nil && b == nil {
+		return nil
+	}
+
+	result := make([]T, 0, len(a)+len(b))
+	result = append(result, a...)
+	return append(result, b...)
+}
+
+// mergePodAffinity returns a new PodAffinity with the terms of override
+// appended to the ones of base or nil if both are nil
+func mergePodAffinity(base, override *corev1.PodAffinity) *corev1.PodAffinity {
+	if base == nil && override == nil {
+		return nil
+	}
+	if base == nil {
+		base = &corev1.PodAffinity{}
+	}
+	if override == nil {
+		override = &corev1.PodAffinity{}
+	}
+
+	return &corev1.PodAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution:  concat(base.RequiredDuringSchedulingIgnoredDuringExecution, override.RequiredDuringSchedulingIgnoredDuringExecution),
+		PreferredDuringSchedulingIgnoredDuringExecution: concat(base.PreferredDuringSchedulingIgnoredDuringExecution, override.PreferredDuringSchedulingIgnoredDuringExecution),
+	}
+}
+
+// mergePodAntiAffinity returns a new PodAntiAffinity with the terms of
+// override appended to the ones of base or nil if both are nil
+func mergePodAntiAffinity(base, override *corev1.PodAntiAffinity) *corev1.PodAntiAffinity {
+	if base == nil && override == nil {
+		return nil
+	}
+	if base == nil {
+		base = &corev1.PodAntiAffinity{}
+	}
+	if override == nil {
+		override = &corev1.PodAntiAffinity{}
+	}
+
+	return &corev1.PodAntiAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution:  concat(base.RequiredDuringSchedulingIgnoredDuringExecution, override.RequiredDuringSchedulingIgnoredDuringExecution),
+		PreferredDuringSchedulingIgnoredDuringExecution: concat(base.PreferredDuringSchedulingIgnoredDuringExecution, override.PreferredDuringSchedulingIgnoredDuringExecution),
+	}
+}
+
+// mergeMaps returns a new map with the entries of a and b, the entries of b
+// replacing the ones of a with the same key, or nil if both are nil
+func mergeMaps(a, b map[string]string) map[string]string {
+	if a == nil && b == nil {
+		return nil
+	}
+
+	result := make(map[string]string, len(a)+len(b))
+	for k, v := range a {
+		result[k] = v
+	}
+	for k, v := range b {
+		result[k] = v
+	}
+
+	return result
+}
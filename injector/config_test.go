@@ -0,0 +1,543 @@
+package injector
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/idgenchev/namespace-node-affinity/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestInjectorWithConfig(t *testing.T, data map[string]string, objects ...runtime.Object) *Injector {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-cm",
+			Namespace: "ns-node-affinity",
+		},
+		Data: data,
+	}
+
+	return newTestInjector(t, fake.NewSimpleClientset(append(objects, cm)...), "ns-node-affinity", "test-cm")
+}
+
+func namespace(name string, labels map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+	}
+}
+
+func tolerationKeys(config *NamespaceConfig) []string {
+	var keys []string
+	for _, toleration := range config.Tolerations {
+		keys = append(keys, toleration.Key)
+	}
+	return keys
+}
+
+func TestConfigForNamespaceWithDefault(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name         string
+		data         map[string]string
+		expectedKeys []string
+		expectedErr  error
+	}{
+		{
+			name: "NoEntryUsesDefault",
+			data: map[string]string{
+				defaultConfigKey: "tolerations: [{key: default, operator: Exists}]",
+			},
+			expectedKeys: []string{"default"},
+		},
+		{
+			name: "EntryReplacesDefault",
+			data: map[string]string{
+				defaultConfigKey: "tolerations: [{key: default, operator: Exists}]",
+				"testing-ns":     "tolerations: [{key: ns, operator: Exists}]",
+			},
+			expectedKeys: []string{"ns"},
+		},
+		{
+			name: "EntryExtendsDefault",
+			data: map[string]string{
+				defaultConfigKey: "tolerations: [{key: default, operator: Exists}]",
+				"testing-ns":     "{mergeDefault: true, tolerations: [{key: ns, operator: Exists}]}",
+			},
+			expectedKeys: []string{"default", "ns"},
+		},
+		{
+			name: "DefaultMergeModeIsInherited",
+			data: map[string]string{
+				defaultConfigKey: "{mergeDefault: true, tolerations: [{key: default, operator: Exists}]}",
+				"testing-ns":     "tolerations: [{key: ns, operator: Exists}]",
+			},
+			expectedKeys: []string{"default", "ns"},
+		},
+		{
+			name: "EntryOptsOutOfInheritedMergeMode",
+			data: map[string]string{
+				defaultConfigKey: "{mergeDefault: true, tolerations: [{key: default, operator: Exists}]}",
+				"testing-ns":     "{mergeDefault: false, tolerations: [{key: ns, operator: Exists}]}",
+			},
+			expectedKeys: []string{"ns"},
+		},
+		{
+			name: "EntryOnlyValidWhenMerged",
+			data: map[string]string{
+				defaultConfigKey: "tolerations: [{key: default, operator: Exists}]",
+				"testing-ns":     "{mergeDefault: true, excludedLabels: {ignore: me}}",
+			},
+			expectedKeys: []string{"default"},
+		},
+		{
+			name: "InvalidDefault",
+			data: map[string]string{
+				defaultConfigKey: "tolerations: invalid",
+			},
+			expectedErr: ErrInvalidConfiguration,
+		},
+		{
+			name:        "NoEntryAndNoDefault",
+			data:        map[string]string{"other-ns": "tolerations: [{key: other, operator: Exists}]"},
+			expectedErr: ErrMissingConfiguration,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := newTestInjectorWithConfig(t, tc.data)
+
+			config, _, err := m.configForNamespace("testing-ns")
+			if tc.expectedErr != nil {
+				assert.True(t, errors.Is(err, tc.expectedErr))
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedKeys, tolerationKeys(config))
+		})
+	}
+}
+
+func TestMergeNamespaceConfigs(t *testing.T) {
+	t.Parallel()
+
+	base := &NamespaceConfig{
+		NodeSelectorTerms: nodeSelectorTerms(),
+		Tolerations:       tolerations()[:1],
+		ExcludedLabels:    map[string]string{"base": "label"},
+	}
+	override := &NamespaceConfig{
+		Tolerations: tolerations()[1:],
+	}
+
+	merged := mergeNamespaceConfigs(base, override)
+
+	assert.Equal(t, nodeSelectorTerms(), merged.NodeSelectorTerms)
+	assert.Nil(t, merged.PreferredNodeSelectorTerms)
+	assert.Equal(t, tolerations(), merged.Tolerations)
+	assert.Equal(t, base.ExcludedLabels, merged.ExcludedLabels)
+	assert.Len(t, base.Tolerations, 1, "the base config should not be modified")
+
+	override.ExcludedLabels = map[string]string{"override": "label"}
+	merged = mergeNamespaceConfigs(base, override)
+	assert.Equal(t, override.ExcludedLabels, merged.ExcludedLabels)
+
+	base.ExcludeSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"base": "label"}}
+	merged = mergeNamespaceConfigs(base, override)
+	assert.Equal(t, base.ExcludeSelector, merged.ExcludeSelector)
+
+	override.ExcludeSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"override": "label"}}
+	merged = mergeNamespaceConfigs(base, override)
+	assert.Equal(t, override.ExcludeSelector, merged.ExcludeSelector)
+
+	base.NodeSelector = map[string]string{"pool": "base", "zone": "a"}
+	override.NodeSelector = map[string]string{"pool": "override"}
+	merged = mergeNamespaceConfigs(base, override)
+	assert.Equal(t, map[string]string{"pool": "override", "zone": "a"}, merged.NodeSelector)
+	assert.Equal(t, "base", base.NodeSelector["pool"], "the base config should not be modified")
+
+	base.Mode = v1alpha1.ModeAudit
+	merged = mergeNamespaceConfigs(base, override)
+	assert.Equal(t, v1alpha1.ModeAudit, merged.Mode)
+
+	override.Mode = v1alpha1.ModeEnforce
+	merged = mergeNamespaceConfigs(base, override)
+	assert.Equal(t, v1alpha1.ModeEnforce, merged.Mode)
+
+	base.PodAntiAffinity = &corev1.PodAntiAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{{TopologyKey: "base"}},
+	}
+	override.PodAntiAffinity = &corev1.PodAntiAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{{TopologyKey: "override"}},
+	}
+	merged = mergeNamespaceConfigs(base, override)
+	assert.Nil(t, merged.PodAffinity)
+	assert.Equal(t, []corev1.PodAffinityTerm{{TopologyKey: "base"}, {TopologyKey: "override"}},
+		merged.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution)
+}
+
+func TestConfigForNamespaceWithNamespaceSelector(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name         string
+		data         map[string]string
+		expectedKeys []string
+		expectedErr  error
+	}{
+		{
+			name: "MatchingSelector",
+			data: map[string]string{
+				"data-pool": "{namespaceSelector: {matchLabels: {team: data}}, tolerations: [{key: data, operator: Exists}]}",
+			},
+			expectedKeys: []string{"data"},
+		},
+		{
+			name: "MatchingExpression",
+			data: map[string]string{
+				"data-pool": "{namespaceSelector: {matchExpressions: [{key: team, operator: In, values: [data, ml]}]}, tolerations: [{key: data, operator: Exists}]}",
+			},
+			expectedKeys: []string{"data"},
+		},
+		{
+			name: "NonMatchingSelectorFallsBackToDefault",
+			data: map[string]string{
+				defaultConfigKey: "tolerations: [{key: default, operator: Exists}]",
+				"web-pool":       "{namespaceSelector: {matchLabels: {team: web}}, tolerations: [{key: web, operator: Exists}]}",
+			},
+			expectedKeys: []string{"default"},
+		},
+		{
+			name: "ExactNameWins",
+			data: map[string]string{
+				"testing-ns": "tolerations: [{key: ns, operator: Exists}]",
+				"data-pool":  "{namespaceSelector: {matchLabels: {team: data}}, tolerations: [{key: data, operator: Exists}]}",
+			},
+			expectedKeys: []string{"ns"},
+		},
+		{
+			name: "SelectorEntryIsNotMatchedByName",
+			data: map[string]string{
+				"testing-ns": "{namespaceSelector: {matchLabels: {team: web}}, tolerations: [{key: web, operator: Exists}]}",
+			},
+			expectedErr: ErrMissingConfiguration,
+		},
+		{
+			name: "MostRequirementsWin",
+			data: map[string]string{
+				"a-data":     "{namespaceSelector: {matchLabels: {team: data}}, tolerations: [{key: data, operator: Exists}]}",
+				"b-data-gpu": "{namespaceSelector: {matchLabels: {team: data, gpu: \"true\"}}, tolerations: [{key: gpu, operator: Exists}]}",
+			},
+			expectedKeys: []string{"gpu"},
+		},
+		{
+			name: "TiesAreBrokenByName",
+			data: map[string]string{
+				"b-data": "{namespaceSelector: {matchLabels: {team: data}}, tolerations: [{key: b, operator: Exists}]}",
+				"a-gpu":  "{namespaceSelector: {matchLabels: {gpu: \"true\"}}, tolerations: [{key: a, operator: Exists}]}",
+			},
+			expectedKeys: []string{"a"},
+		},
+		{
+			name: "UnparsableEntriesAreSkipped",
+			data: map[string]string{
+				"broken":    "tolerations: invalid",
+				"data-pool": "{namespaceSelector: {matchLabels: {team: data}}, tolerations: [{key: data, operator: Exists}]}",
+			},
+			expectedKeys: []string{"data"},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ns := namespace("testing-ns", map[string]string{"team": "data", "gpu": "true"})
+			m := newTestInjectorWithConfig(t, tc.data, ns)
+
+			config, _, err := m.configForNamespace("testing-ns")
+			if tc.expectedErr != nil {
+				assert.True(t, errors.Is(err, tc.expectedErr))
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedKeys, tolerationKeys(config))
+		})
+	}
+}
+
+func TestConfigForNamespaceWithNamespaceSelectorPolicy(t *testing.T) {
+	t.Parallel()
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-cm",
+			Namespace: "ns-node-affinity",
+		},
+		Data: map[string]string{
+			"a-data": "{namespaceSelector: {matchLabels: {team: data}}, tolerations: [{key: from-cm, operator: Exists}]}",
+		},
+	}
+	spec := NamespaceConfig{
+		NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "data"}},
+		Tolerations:       []corev1.Toleration{{Key: "from-policy", Operator: corev1.TolerationOpExists}},
+	}
+	ns := namespace("testing-ns", map[string]string{"team": "data"})
+	m, _ := newTestInjectorWithPolicies(t, []runtime.Object{cm, ns}, policy(t, "z-data", spec))
+
+	config, _, err := m.configForNamespace("testing-ns")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"from-policy"}, tolerationKeys(config))
+}
+
+func TestConfigForNamespaceWithPatternKeys(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name         string
+		namespace    string
+		data         map[string]string
+		expectedKeys []string
+		expectedErr  error
+	}{
+		{
+			name:      "Glob",
+			namespace: "ci-build",
+			data: map[string]string{
+				patternsConfigKey: "ci-*: {tolerations: [{key: ci, operator: Exists}]}",
+			},
+			expectedKeys: []string{"ci"},
+		},
+		{
+			name:      "Regexp",
+			namespace: "preview-pr-1234",
+			data: map[string]string{
+				patternsConfigKey: `re:^preview-pr-\d+$: {tolerations: [{key: preview, operator: Exists}]}`,
+			},
+			expectedKeys: []string{"preview"},
+		},
+		{
+			name:      "NonMatchingRegexp",
+			namespace: "preview-pr-abc",
+			data: map[string]string{
+				patternsConfigKey: `re:^preview-pr-\d+$: {tolerations: [{key: preview, operator: Exists}]}`,
+				defaultConfigKey:  "tolerations: [{key: default, operator: Exists}]",
+			},
+			expectedKeys: []string{"default"},
+		},
+		{
+			name:      "ExactNameWins",
+			namespace: "ci-build",
+			data: map[string]string{
+				patternsConfigKey: "ci-*: {tolerations: [{key: ci, operator: Exists}]}",
+				"ci-build":        "tolerations: [{key: exact, operator: Exists}]",
+			},
+			expectedKeys: []string{"exact"},
+		},
+		{
+			name:      "LongestPatternWins",
+			namespace: "ci-build-arm",
+			data: map[string]string{
+				patternsConfigKey: `
+ci-*: {tolerations: [{key: ci, operator: Exists}]}
+ci-build-*: {tolerations: [{key: ci-build, operator: Exists}]}
+re:^ci-.*-arm: {tolerations: [{key: re, operator: Exists}]}
+`,
+			},
+			expectedKeys: []string{"ci-build"},
+		},
+		{
+			name:      "TiesAreBrokenByKey",
+			namespace: "ci-build",
+			data: map[string]string{
+				patternsConfigKey: `
+ci-b*: {tolerations: [{key: b, operator: Exists}]}
+ci-*d: {tolerations: [{key: d, operator: Exists}]}
+`,
+			},
+			expectedKeys: []string{"d"},
+		},
+		{
+			name:      "PatternWinsOverSelector",
+			namespace: "ci-build",
+			data: map[string]string{
+				patternsConfigKey: "ci-*: {tolerations: [{key: ci, operator: Exists}]}",
+				"data-pool":       "{namespaceSelector: {matchLabels: {team: data}}, tolerations: [{key: data, operator: Exists}]}",
+			},
+			expectedKeys: []string{"ci"},
+		},
+		{
+			name:      "InvalidRegexpIsSkipped",
+			namespace: "ci-build",
+			data: map[string]string{
+				patternsConfigKey: `
+re:(: {tolerations: [{key: invalid, operator: Exists}]}
+ci-*: {tolerations: [{key: ci, operator: Exists}]}
+`,
+			},
+			expectedKeys: []string{"ci"},
+		},
+		{
+			name:      "InvalidPatterns",
+			namespace: "ci-build",
+			data: map[string]string{
+				patternsConfigKey: "ci-*: {tolerations: invalid}",
+			},
+			expectedErr: ErrInvalidConfiguration,
+		},
+		{
+			name:      "PatternWithNamespaceSelector",
+			namespace: "ci-build",
+			data: map[string]string{
+				patternsConfigKey: "ci-*: {namespaceSelector: {matchLabels: {team: data}}, tolerations: [{key: ci, operator: Exists}]}",
+			},
+			expectedErr: ErrInvalidConfiguration,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ns := namespace(tc.namespace, map[string]string{"team": "data"})
+			m := newTestInjectorWithConfig(t, tc.data, ns)
+
+			config, _, err := m.configForNamespace(tc.namespace)
+			if tc.expectedErr != nil {
+				assert.True(t, errors.Is(err, tc.expectedErr))
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedKeys, tolerationKeys(config))
+		})
+	}
+}
+
+func TestConfigForNamespaceWithProfiles(t *testing.T) {
+	t.Parallel()
+
+	profiles := `
+gpu:
+  tolerations: [{key: gpu, operator: Exists}]
+spot:
+  use: [preemptible]
+  tolerations: [{key: spot, operator: Exists}]
+preemptible:
+  tolerations: [{key: preemptible, operator: Exists}]
+loop-a:
+  use: [loop-b]
+loop-b:
+  use: [loop-a]
+`
+
+	testCases := []struct {
+		name         string
+		data         map[string]string
+		expectedKeys []string
+		expectedErr  error
+	}{
+		{
+			name: "ProfilesAreComposedInOrder",
+			data: map[string]string{
+				profilesConfigKey: profiles,
+				"testing-ns":      "{use: [gpu, spot], tolerations: [{key: ns, operator: Exists}]}",
+			},
+			expectedKeys: []string{"gpu", "preemptible", "spot", "ns"},
+		},
+		{
+			name: "OnlyProfiles",
+			data: map[string]string{
+				profilesConfigKey: profiles,
+				"testing-ns":      "use: [spot]",
+			},
+			expectedKeys: []string{"preemptible", "spot"},
+		},
+		{
+			name: "DefaultUsesProfiles",
+			data: map[string]string{
+				profilesConfigKey: profiles,
+				defaultConfigKey:  "{mergeDefault: true, use: [gpu]}",
+				"testing-ns":      "use: [spot]",
+			},
+			expectedKeys: []string{"gpu", "preemptible", "spot"},
+		},
+		{
+			name: "MissingProfile",
+			data: map[string]string{
+				profilesConfigKey: profiles,
+				"testing-ns":      "use: [missing]",
+			},
+			expectedErr: ErrInvalidConfiguration,
+		},
+		{
+			name: "MissingProfilesKey",
+			data: map[string]string{
+				"testing-ns": "use: [gpu]",
+			},
+			expectedErr: ErrInvalidConfiguration,
+		},
+		{
+			name: "ReferenceCycle",
+			data: map[string]string{
+				profilesConfigKey: profiles,
+				"testing-ns":      "use: [loop-a]",
+			},
+			expectedErr: ErrInvalidConfiguration,
+		},
+		{
+			name: "InvalidProfiles",
+			data: map[string]string{
+				profilesConfigKey: "gpu: {tolerations: invalid}",
+				"testing-ns":      "use: [gpu]",
+			},
+			expectedErr: ErrInvalidConfiguration,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := newTestInjectorWithConfig(t, tc.data)
+
+			config, _, err := m.configForNamespace("testing-ns")
+			if tc.expectedErr != nil {
+				assert.True(t, errors.Is(err, tc.expectedErr))
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedKeys, tolerationKeys(config))
+		})
+	}
+}
+
+func TestComposeProfilesReportsTheCycle(t *testing.T) {
+	t.Parallel()
+
+	profiles := map[string]*NamespaceConfig{
+		"a": {Use: []string{"b"}},
+		"b": {Use: []string{"a"}},
+	}
+
+	_, err := composeProfiles(&NamespaceConfig{Use: []string{"a"}}, profiles, nil)
+	assert.True(t, errors.Is(err, ErrInvalidConfiguration))
+	assert.Contains(t, err.Error(), "a -> b -> a")
+}
@@ -0,0 +1,55 @@
+package injector
+
+import (
+	"fmt"
+	"net/http"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// hasNodeAffinityConflict reports whether config adds node affinity to a pod
+// with podSpec which already has node affinity of its own
+func hasNodeAffinityConflict(config *NamespaceConfig, podSpec corev1.PodSpec) bool {
+	if config.NodeSelectorTerms == nil && config.PreferredNodeSelectorTerms == nil {
+		return false
+	}
+
+	if podSpec.Affinity == nil || podSpec.Affinity.NodeAffinity == nil {
+		return false
+	}
+
+	nodeAffinity := podSpec.Affinity.NodeAffinity
+	return len(podNodeSelectorTerms(podSpec)) > 0 || len(nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution) > 0
+}
+
+// buildReplaceNodeAffinityPatch returns a patch replacing the node affinity of
+// the pod with the node affinity of config
+func buildReplaceNodeAffinityPatch(config *NamespaceConfig) JSONPatch {
+	nodeAffinity := &corev1.NodeAffinity{
+		PreferredDuringSchedulingIgnoredDuringExecution: config.PreferredNodeSelectorTerms,
+	}
+
+	if config.NodeSelectorTerms != nil {
+		nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = &corev1.NodeSelector{
+			NodeSelectorTerms: config.NodeSelectorTerms,
+		}
+	}
+
+	return JSONPatch{
+		Op:    "replace",
+		Path:  CreateNodeAffinity,
+		Value: nodeAffinity,
+	}
+}
+
+// conflictStatus returns the status for denying the admission of a pod with
+// node affinity of its own in namespace
+func conflictStatus(namespace string) *metav1.Status {
+	return &metav1.Status{
+		Status:  metav1.StatusFailure,
+		Message: fmt.Sprintf("pods in namespace %s must not set their own node affinity", namespace),
+		Reason:  metav1.StatusReasonForbidden,
+		Code:    http.StatusForbidden,
+	}
+}
@@ -0,0 +1,171 @@
+package injector
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/idgenchev/namespace-node-affinity/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	v1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestHasNodeAffinityConflict(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		config   *NamespaceConfig
+		podSpec  corev1.PodSpec
+		expected bool
+	}{
+		{
+			name:    "NoAffinity",
+			config:  &NamespaceConfig{NodeSelectorTerms: nodeSelectorTerms()},
+			podSpec: podSpecWithNoAffinity,
+		},
+		{
+			name:    "EmptyNodeAffinity",
+			config:  &NamespaceConfig{NodeSelectorTerms: nodeSelectorTerms()},
+			podSpec: podSpecWithNoRequiredDuringSchedulingIgnoreDuringExecution,
+		},
+		{
+			name:     "ExistingNodeSelectorTerms",
+			config:   &NamespaceConfig{NodeSelectorTerms: nodeSelectorTerms()},
+			podSpec:  podSpecWithExistingNodeSelectorTerms,
+			expected: true,
+		},
+		{
+			name:     "ExistingPreferredTerms",
+			config:   &NamespaceConfig{PreferredNodeSelectorTerms: preferredSchedulingTerms()},
+			podSpec:  podSpecWithExistingPreferredAffinity,
+			expected: true,
+		},
+		{
+			name:    "ConfigWithoutNodeAffinity",
+			config:  &NamespaceConfig{Tolerations: tolerations()},
+			podSpec: podSpecWithExistingNodeSelectorTerms,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.expected, hasNodeAffinityConflict(tc.config, tc.podSpec))
+		})
+	}
+}
+
+func TestBuildPatchWithReplaceConflictStrategy(t *testing.T) {
+	t.Parallel()
+
+	config := &NamespaceConfig{
+		NodeSelectorTerms:          nodeSelectorTerms(),
+		PreferredNodeSelectorTerms: preferredSchedulingTerms(),
+		Tolerations:                tolerations()[:1],
+		ConflictStrategy:           v1alpha1.ConflictStrategyReplace,
+	}
+
+	patch, err := buildPatch(config, &corev1.Pod{Spec: podSpecWithExistingNodeSelectorTerms})
+	assert.NoError(t, err)
+
+	expectedPatches := []JSONPatch{
+		{
+			Op:   "replace",
+			Path: CreateNodeAffinity,
+			Value: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+					NodeSelectorTerms: nodeSelectorTerms(),
+				},
+				PreferredDuringSchedulingIgnoredDuringExecution: preferredSchedulingTerms(),
+			},
+		},
+		{Op: "add", Path: CreateTolerations, Value: []corev1.Toleration{}},
+		{Op: "add", Path: AddTolerations, Value: tolerations()[0]},
+	}
+	expectedPatch, err := json.Marshal(expectedPatches)
+	assert.NoError(t, err)
+	assert.JSONEq(t, string(expectedPatch), string(patch))
+
+	// Pods without node affinity get the terms added as usual
+	patch, err = buildPatch(config, &corev1.Pod{})
+	assert.NoError(t, err)
+
+	var patches []JSONPatch
+	assert.NoError(t, json.Unmarshal(patch, &patches))
+	assert.Equal(t, CreateAffinity, string(patches[0].Path))
+	assert.Equal(t, "add", patches[0].Op)
+}
+
+func TestMutateWithConflictStrategy(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name             string
+		conflictStrategy string
+		expectedAllowed  bool
+		expectedPatch    bool
+		expectedCode     int32
+	}{
+		{
+			name:             "Append",
+			conflictStrategy: "append",
+			expectedAllowed:  true,
+			expectedPatch:    true,
+		},
+		{
+			name:             "Replace",
+			conflictStrategy: "replace",
+			expectedAllowed:  true,
+			expectedPatch:    true,
+		},
+		{
+			name:             "Skip",
+			conflictStrategy: "skip",
+			expectedAllowed:  true,
+		},
+		{
+			name:             "Reject",
+			conflictStrategy: "reject",
+			expectedCode:     http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := newTestInjectorWithConfig(t, map[string]string{
+				"testing-ns": "{conflictStrategy: " + tc.conflictStrategy + ", nodeSelectorTerms: [{matchExpressions: [{key: pool, operator: In, values: [a]}]}]}",
+			})
+
+			admissionReview := v1beta1.AdmissionReview{
+				Request: &v1beta1.AdmissionRequest{
+					Namespace: "testing-ns",
+					Object: runtime.RawExtension{
+						Object: &corev1.Pod{Spec: podSpecWithExistingNodeSelectorTerms},
+					},
+				},
+			}
+			j, err := json.Marshal(admissionReview)
+			assert.NoError(t, err)
+
+			body, err := m.Mutate(j)
+			assert.NoError(t, err)
+
+			resp := v1beta1.AdmissionReview{}
+			assert.NoError(t, json.Unmarshal(body, &resp))
+			assert.Equal(t, tc.expectedAllowed, resp.Response.Allowed)
+			assert.Equal(t, tc.expectedPatch, resp.Response.Patch != nil)
+			assert.Equal(t, tc.expectedCode, resp.Response.Result.Code)
+			if !tc.expectedAllowed {
+				assert.Contains(t, resp.Response.Result.Message, "testing-ns")
+			}
+		})
+	}
+}
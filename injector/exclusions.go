@@ -0,0 +1,103 @@
+package injector
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// defaultExcludedOwnerKinds are the owner kinds excluded when the config does
+// not set excludedOwnerKinds. Pinning DaemonSet pods to a subset of the nodes
+// breaks their node coverage
+var defaultExcludedOwnerKinds = []string{"DaemonSet"}
+
+// ignorePod reports whether pod matches any of the exclusions of config
+func ignorePod(pod *corev1.Pod, config *NamespaceConfig) (bool, error) {
+	if ignoreMirrorPod(pod, config) ||
+		ignorePodWithOwnerKinds(pod, config) ||
+		ignorePodWithServiceAccount(pod, config) ||
+		ignorePodWithAnnotations(pod.Annotations, config) ||
+		ignorePodWithLabels(pod.Labels, config) {
+		return true, nil
+	}
+
+	return ignorePodWithSelector(pod.Labels, config)
+}
+
+// ignoreMirrorPod reports whether pod is the mirror pod of a static pod.
+// Mirror pods are excluded unless excludeMirrorPods is set to false
+func ignoreMirrorPod(pod *corev1.Pod, config *NamespaceConfig) bool {
+	if config.ExcludeMirrorPods != nil && !*config.ExcludeMirrorPods {
+		return false
+	}
+
+	_, ok := pod.Annotations[corev1.MirrorPodAnnotationKey]
+	return ok
+}
+
+func ignorePodWithOwnerKinds(pod *corev1.Pod, config *NamespaceConfig) bool {
+	kinds := config.ExcludedOwnerKinds
+	if kinds == nil {
+		kinds = defaultExcludedOwnerKinds
+	}
+
+	for _, owner := range pod.OwnerReferences {
+		for _, kind := range kinds {
+			if owner.Kind == kind {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func ignorePodWithServiceAccount(pod *corev1.Pod, config *NamespaceConfig) bool {
+	for _, serviceAccount := range config.ExcludedServiceAccounts {
+		if pod.Spec.ServiceAccountName == serviceAccount {
+			return true
+		}
+	}
+
+	return false
+}
+
+func ignorePodWithAnnotations(podAnnotations map[string]string, config *NamespaceConfig) bool {
+	return matchesAll(podAnnotations, config.ExcludedAnnotations)
+}
+
+func ignorePodWithLabels(podLabels map[string]string, config *NamespaceConfig) bool {
+	return matchesAll(podLabels, config.ExcludedLabels)
+}
+
+func ignorePodWithSelector(podLabels map[string]string, config *NamespaceConfig) (bool, error) {
+	if config.ExcludeSelector == nil {
+		return false, nil
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(config.ExcludeSelector)
+	if err != nil {
+		return false, fmt.Errorf("%w: invalid excludeSelector: %s", ErrInvalidConfiguration, err)
+	}
+
+	return selector.Matches(labels.Set(podLabels)), nil
+}
+
+// matchesAll reports whether values has every key-value pair of expected.
+// An empty expected never matches
+func matchesAll(values, expected map[string]string) bool {
+	if len(expected) == 0 {
+		return false
+	}
+
+	numMatched := 0
+	for k, v := range expected {
+		if val, ok := values[k]; ok && val == v {
+			numMatched++
+		}
+	}
+
+	return numMatched == len(expected)
+}
@@ -0,0 +1,210 @@
+package injector
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIgnorePod(t *testing.T) {
+	t.Parallel()
+
+	daemonSetOwner := []metav1.OwnerReference{{Kind: "DaemonSet", Name: "agent"}}
+	replicaSetOwner := []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "web"}}
+	mirrorAnnotations := map[string]string{corev1.MirrorPodAnnotationKey: "hash"}
+
+	testCases := []struct {
+		name     string
+		config   *NamespaceConfig
+		pod      *corev1.Pod
+		expected bool
+	}{
+		{
+			name:   "PlainPod",
+			config: &NamespaceConfig{},
+			pod:    &corev1.Pod{},
+		},
+		{
+			name:     "DaemonSetPodByDefault",
+			config:   &NamespaceConfig{},
+			pod:      &corev1.Pod{ObjectMeta: metav1.ObjectMeta{OwnerReferences: daemonSetOwner}},
+			expected: true,
+		},
+		{
+			name:   "ReplicaSetPodByDefault",
+			config: &NamespaceConfig{},
+			pod:    &corev1.Pod{ObjectMeta: metav1.ObjectMeta{OwnerReferences: replicaSetOwner}},
+		},
+		{
+			name:   "DaemonSetPodWithoutOwnerKinds",
+			config: &NamespaceConfig{ExcludedOwnerKinds: []string{}},
+			pod:    &corev1.Pod{ObjectMeta: metav1.ObjectMeta{OwnerReferences: daemonSetOwner}},
+		},
+		{
+			name:     "ReplicaSetPodWithOwnerKinds",
+			config:   &NamespaceConfig{ExcludedOwnerKinds: []string{"ReplicaSet"}},
+			pod:      &corev1.Pod{ObjectMeta: metav1.ObjectMeta{OwnerReferences: replicaSetOwner}},
+			expected: true,
+		},
+		{
+			name:     "MirrorPodByDefault",
+			config:   &NamespaceConfig{},
+			pod:      &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: mirrorAnnotations}},
+			expected: true,
+		},
+		{
+			name:   "MirrorPodNotExcluded",
+			config: &NamespaceConfig{ExcludeMirrorPods: boolPtr(false)},
+			pod:    &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: mirrorAnnotations}},
+		},
+		{
+			name:     "ServiceAccount",
+			config:   &NamespaceConfig{ExcludedServiceAccounts: []string{"cni", "monitoring"}},
+			pod:      &corev1.Pod{Spec: corev1.PodSpec{ServiceAccountName: "monitoring"}},
+			expected: true,
+		},
+		{
+			name:   "OtherServiceAccount",
+			config: &NamespaceConfig{ExcludedServiceAccounts: []string{"cni", "monitoring"}},
+			pod:    &corev1.Pod{Spec: corev1.PodSpec{ServiceAccountName: "default"}},
+		},
+		{
+			name:   "SomeAnnotations",
+			config: &NamespaceConfig{ExcludedAnnotations: map[string]string{"a": "1", "b": "2"}},
+			pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{"a": "1"},
+			}},
+		},
+		{
+			name:   "AllAnnotations",
+			config: &NamespaceConfig{ExcludedAnnotations: map[string]string{"a": "1", "b": "2"}},
+			pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{"a": "1", "b": "2", "c": "3"},
+			}},
+			expected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ignore, err := ignorePod(tc.pod, tc.config)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, ignore)
+		})
+	}
+}
+
+func TestIgnorePodWithLabels(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		config      *NamespaceConfig
+		podLabels   map[string]string
+		expected    bool
+		expectedErr error
+	}{
+		{
+			name:      "NoExclusions",
+			config:    &NamespaceConfig{},
+			podLabels: map[string]string{"app": "web"},
+		},
+		{
+			name:      "AllExcludedLabels",
+			config:    &NamespaceConfig{ExcludedLabels: map[string]string{"app": "web", "tier": "frontend"}},
+			podLabels: map[string]string{"app": "web", "tier": "frontend"},
+			expected:  true,
+		},
+		{
+			name:      "SomeExcludedLabels",
+			config:    &NamespaceConfig{ExcludedLabels: map[string]string{"app": "web", "tier": "frontend"}},
+			podLabels: map[string]string{"app": "web"},
+		},
+		{
+			name: "SelectorMatchLabels",
+			config: &NamespaceConfig{
+				ExcludeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			},
+			podLabels: map[string]string{"app": "web"},
+			expected:  true,
+		},
+		{
+			name: "SelectorExists",
+			config: &NamespaceConfig{
+				ExcludeSelector: &metav1.LabelSelector{
+					MatchExpressions: []metav1.LabelSelectorRequirement{
+						{Key: "ignore-me", Operator: metav1.LabelSelectorOpExists},
+					},
+				},
+			},
+			podLabels: map[string]string{"ignore-me": ""},
+			expected:  true,
+		},
+		{
+			name: "SelectorIn",
+			config: &NamespaceConfig{
+				ExcludeSelector: &metav1.LabelSelector{
+					MatchExpressions: []metav1.LabelSelectorRequirement{
+						{Key: "app", Operator: metav1.LabelSelectorOpIn, Values: []string{"web", "api"}},
+					},
+				},
+			},
+			podLabels: map[string]string{"app": "api"},
+			expected:  true,
+		},
+		{
+			name: "SelectorNotIn",
+			config: &NamespaceConfig{
+				ExcludeSelector: &metav1.LabelSelector{
+					MatchExpressions: []metav1.LabelSelectorRequirement{
+						{Key: "app", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"web", "api"}},
+					},
+				},
+			},
+			podLabels: map[string]string{"app": "api"},
+		},
+		{
+			name: "EitherExclusionMatches",
+			config: &NamespaceConfig{
+				ExcludedLabels:  map[string]string{"app": "web"},
+				ExcludeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "api"}},
+			},
+			podLabels: map[string]string{"app": "api"},
+			expected:  true,
+		},
+		{
+			name: "InvalidSelector",
+			config: &NamespaceConfig{
+				ExcludeSelector: &metav1.LabelSelector{
+					MatchExpressions: []metav1.LabelSelectorRequirement{
+						{Key: "app", Operator: metav1.LabelSelectorOpIn},
+					},
+				},
+			},
+			podLabels:   map[string]string{"app": "api"},
+			expectedErr: ErrInvalidConfiguration,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: tc.podLabels}}
+			ignore, err := ignorePod(pod, tc.config)
+			assert.True(t, errors.Is(err, tc.expectedErr))
+			assert.Equal(t, tc.expected, ignore)
+		})
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
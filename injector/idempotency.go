@@ -0,0 +1,48 @@
+package injector
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// configHashAnnotationKey is the pod annotation holding the hash of the
+// configuration applied to the pod. Pods with the hash of their current
+// configuration are not patched again when the API server reinvokes the
+// webhook
+const configHashAnnotationKey = "namespace-node-affinity.idgenchev.github.com/config-hash"
+
+// Annotations JSON patch paths
+const (
+	CreateAnnotations = "/metadata/annotations"
+	AddAnnotation     = "/metadata/annotations/"
+)
+
+// configHash returns the hex encoded SHA-256 hash of config. The config is
+// hashed after applying the rules, so pods matching different rules get
+// different hashes
+func configHash(config *NamespaceConfig) (string, error) {
+	h := sha256.New()
+	if err := json.NewEncoder(h).Encode(config); err != nil {
+		return "", fmt.Errorf("%w: %s", ErrFailedToCreatePatch, err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// configApplied reports whether the configuration with hash has already been
+// applied to pod. The annotation can be set by anyone creating the pod, so
+// the pod must also have the nodeSelectorTerms, tolerations and nodeSelector
+// of config
+func configApplied(pod *corev1.Pod, config *NamespaceConfig, hash string) bool {
+	if pod.Annotations[configHashAnnotationKey] != hash {
+		return false
+	}
+
+	return len(missingNodeSelectorTerms(config.NodeSelectorTerms, pod.Spec)) == 0 &&
+		len(missingTolerations(config, pod.Spec)) == 0 &&
+		len(missingNodeSelector(config, pod.Spec)) == 0
+}
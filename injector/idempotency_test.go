@@ -0,0 +1,189 @@
+package injector
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// mutatePatch returns the patch Mutate returns for pod in namespace with the
+// ConfigMap entry config
+func mutatePatch(t *testing.T, config *NamespaceConfig, namespace string, pod *corev1.Pod) []byte {
+	patches, err := buildPatches(config, pod)
+	assert.NoError(t, err)
+
+	hash, err := configHash(config)
+	assert.NoError(t, err)
+
+	applied := &appliedConfig{configSource: configSource{Kind: configMapSourceKind, Entry: namespace}, Hash: hash}
+	annotationsPatches, err := buildConfigAnnotationsPatches(pod, applied)
+	assert.NoError(t, err)
+
+	patch, err := marshalPatches(append(patches, annotationsPatches...))
+	assert.NoError(t, err)
+
+	return patch
+}
+
+// withoutConfigHashPatch returns patch without the config hash patch added
+// by Mutate
+func withoutConfigHashPatch(t *testing.T, patch []byte) string {
+	var patches []JSONPatch
+	assert.NoError(t, json.Unmarshal(patch, &patches))
+
+	if assert.NotEmpty(t, patches) {
+		assert.Equal(t, PatchPath(CreateAnnotations), patches[len(patches)-1].Path)
+		patches = patches[:len(patches)-1]
+	}
+
+	j, err := json.Marshal(patches)
+	assert.NoError(t, err)
+
+	return string(j)
+}
+
+func TestConfigHash(t *testing.T) {
+	t.Parallel()
+
+	hash, err := configHash(&NamespaceConfig{Tolerations: tolerations()})
+	assert.NoError(t, err)
+
+	sameHash, err := configHash(&NamespaceConfig{Tolerations: tolerations()})
+	assert.NoError(t, err)
+
+	otherHash, err := configHash(&NamespaceConfig{Tolerations: tolerations()[:1]})
+	assert.NoError(t, err)
+
+	assert.Len(t, hash, 64)
+	assert.Equal(t, hash, sameHash)
+	assert.NotEqual(t, hash, otherHash)
+}
+
+func TestMutateIsIdempotent(t *testing.T) {
+	t.Parallel()
+
+	// The reject conflict strategy would deny the pod if the webhook
+	// checked the node affinity it added for conflicts on reinvocation
+	m := newTestInjectorWithConfig(t, map[string]string{
+		"testing-ns": "{conflictStrategy: reject, nodeSelectorTerms: [{matchExpressions: [{key: pool, operator: In, values: [a]}]}]}",
+	})
+
+	mutate := func(pod *corev1.Pod) *v1beta1.AdmissionResponse {
+		admissionReview := v1beta1.AdmissionReview{
+			Request: &v1beta1.AdmissionRequest{
+				Namespace: "testing-ns",
+				Object:    runtime.RawExtension{Object: pod},
+			},
+		}
+		j, err := json.Marshal(admissionReview)
+		assert.NoError(t, err)
+
+		body, err := m.Mutate(j)
+		assert.NoError(t, err)
+
+		resp := v1beta1.AdmissionReview{}
+		assert.NoError(t, json.Unmarshal(body, &resp))
+
+		return resp.Response
+	}
+
+	resp := mutate(&corev1.Pod{})
+	assert.True(t, resp.Allowed)
+
+	var patches []JSONPatch
+	assert.NoError(t, json.Unmarshal(resp.Patch, &patches))
+	hashPatch := patches[len(patches)-1]
+	assert.Equal(t, PatchPath(CreateAnnotations), hashPatch.Path)
+
+	hash := hashPatch.Value.(map[string]interface{})[configHashAnnotationKey].(string)
+
+	reinvoked := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{configHashAnnotationKey: hash},
+		},
+		Spec: corev1.PodSpec{
+			Affinity: &corev1.Affinity{
+				NodeAffinity: &corev1.NodeAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+						NodeSelectorTerms: []corev1.NodeSelectorTerm{
+							{
+								MatchExpressions: []corev1.NodeSelectorRequirement{
+									{Key: "pool", Operator: corev1.NodeSelectorOpIn, Values: []string{"a"}},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	resp = mutate(reinvoked)
+	assert.True(t, resp.Allowed)
+	assert.Nil(t, resp.Patch)
+
+	reinvoked.Annotations[configHashAnnotationKey] = "stale"
+
+	resp = mutate(reinvoked)
+	assert.False(t, resp.Allowed)
+
+	// A pod created with the hash of the configuration but without the
+	// node affinity is still patched
+	forged := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{configHashAnnotationKey: hash},
+		},
+	}
+
+	resp = mutate(forged)
+	assert.True(t, resp.Allowed)
+
+	patches = nil
+	assert.NoError(t, json.Unmarshal(resp.Patch, &patches))
+	if assert.NotEmpty(t, patches) {
+		assert.Equal(t, PatchPath(CreateAffinity), patches[0].Path)
+	}
+}
+
+func TestMutateIsIdempotentWithAppendedTerms(t *testing.T) {
+	t.Parallel()
+
+	m := newTestInjectorWithConfig(t, map[string]string{
+		"testing-ns": "{nodeSelectorTerms: [{matchExpressions: [{key: pool, operator: In, values: [a]}]}], tolerations: [{key: example-key, operator: Exists, effect: NoSchedule}]}",
+	})
+
+	// The pod keeps its own term, which does not include the requirements of
+	// the term of the namespace appended to it
+	pod := &corev1.Pod{Spec: podSpecWithExistingNodeSelectorTerms}
+	for i := 0; i < 3; i++ {
+		admissionReview := v1beta1.AdmissionReview{
+			Request: &v1beta1.AdmissionRequest{
+				Namespace: "testing-ns",
+				Object:    runtime.RawExtension{Object: pod},
+			},
+		}
+		j, err := json.Marshal(admissionReview)
+		assert.NoError(t, err)
+
+		body, err := m.Mutate(j)
+		assert.NoError(t, err)
+
+		resp := v1beta1.AdmissionReview{}
+		assert.NoError(t, json.Unmarshal(body, &resp))
+		assert.True(t, resp.Response.Allowed)
+
+		if i > 0 {
+			assert.Nil(t, resp.Response.Patch, "invocation %d", i)
+			continue
+		}
+		pod = applyPatch(t, pod, resp.Response.Patch)
+	}
+
+	assert.Len(t, podNodeSelectorTerms(pod.Spec), 2)
+	assert.Len(t, pod.Spec.Tolerations, 1)
+}
@@ -2,16 +2,24 @@
 package injector
 
 import (
-	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
 
+	"github.com/idgenchev/namespace-node-affinity/api/v1alpha1"
 	log "github.com/sirupsen/logrus"
-	v1beta1 "k8s.io/api/admission/v1beta1"
+	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
 	k8sclient "k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/yaml"
 )
 
@@ -23,6 +31,7 @@ var (
 	ErrFailedToReadNodeSelectorTerms = errors.New("failed to load node selector terms")
 	ErrMissingConfiguration          = errors.New("missing configuration")
 	ErrInvalidConfiguration          = errors.New("invalid configuration")
+	ErrCacheSyncFailed               = errors.New("failed to sync informer caches")
 )
 
 // PatchPath is the path for the JSON patch
@@ -41,6 +50,16 @@ const (
 	// tolerations
 	CreateTolerations = "/spec/tolerations"
 	AddTolerations    = "/spec/tolerations/-"
+	// nodeSelector
+	CreateNodeSelector = "/spec/nodeSelector"
+	// scheduling fields
+	SetPriorityClassName = "/spec/priorityClassName"
+	RemovePriority       = "/spec/priority"
+	SetRuntimeClassName  = "/spec/runtimeClassName"
+	SetSchedulerName     = "/spec/schedulerName"
+	// topologySpreadConstraints
+	CreateTopologySpreadConstraints = "/spec/topologySpreadConstraints"
+	AddTopologySpreadConstraints    = "/spec/topologySpreadConstraints/-"
 )
 
 const (
@@ -64,24 +83,119 @@ type JSONPatch struct {
 	Value interface{} `json:"value"`
 }
 
-// NamespaceConfig is the per-namespace configuration
-type NamespaceConfig struct {
-	NodeSelectorTerms          []corev1.NodeSelectorTerm        `json:"nodeSelectorTerms"`
-	PreferredNodeSelectorTerms []corev1.PreferredSchedulingTerm `json:"preferredNodeSelectorTerms"`
-	Tolerations                []corev1.Toleration              `json:"tolerations"`
-	ExcludedLabels             map[string]string                `json:"excludedLabels"`
-}
+// NamespaceConfig is the per-namespace configuration. It shares its schema
+// with the spec of the NamespaceAffinityPolicy objects
+type NamespaceConfig = v1alpha1.NamespaceAffinityPolicySpec
 
 // Injector handles AdmissionReview objects
 type Injector struct {
-	clientset     k8sclient.Interface
-	namespace     string
-	configMapName string
+	clientset       k8sclient.Interface
+	namespace       string
+	configMapName   string
+	informerFactory informers.SharedInformerFactory
+	configMapLister corelisters.ConfigMapNamespaceLister
+	configCache     *configCache[*NamespaceConfig]
+	entriesCache    *configCache[map[string]*NamespaceConfig]
+	resourcesCache  *configCache[[]customResource]
+	regexps         sync.Map
+
+	clusterInformerFactory informers.SharedInformerFactory
+	namespaceLister        corelisters.NamespaceLister
+
+	dynamicClient          dynamic.Interface
+	dynamicInformerFactory dynamicinformer.DynamicSharedInformerFactory
+	policyLister           cache.GenericLister
+	policyCache            *configCache[*NamespaceConfig]
+
+	workloads bool
+
+	nodeLister       corelisters.NodeLister
+	nodesSynced      cache.InformerSynced
+	eventBroadcaster record.EventBroadcaster
+	recorder         record.EventRecorder
+	started          atomic.Bool
+	// reportedConfigs are the namespaces and the hashes of the
+	// unschedulable configurations already reported on admission
+	reportedConfigs sync.Map
+}
+
+// Option configures optional behaviour of the Injector
+type Option func(*Injector)
+
+// NewInjector returns *Injector with k8sclient and configMapName. The
+// ConfigMap is read through a shared informer, so Start needs to be called
+// before the Injector can handle any AdmissionReview
+func NewInjector(k8sclient k8sclient.Interface, namespace string, configMapName string, opts ...Option) *Injector {
+	informerFactory := informers.NewSharedInformerFactoryWithOptions(
+		k8sclient,
+		0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fmt.Sprintf("metadata.name=%s", configMapName)
+		}),
+	)
+
+	clusterInformerFactory := informers.NewSharedInformerFactory(k8sclient, 0)
+
+	m := &Injector{
+		clientset:              k8sclient,
+		namespace:              namespace,
+		configMapName:          configMapName,
+		informerFactory:        informerFactory,
+		configMapLister:        informerFactory.Core().V1().ConfigMaps().Lister().ConfigMaps(namespace),
+		configCache:            newConfigCache[*NamespaceConfig](),
+		entriesCache:           newConfigCache[map[string]*NamespaceConfig](),
+		resourcesCache:         newConfigCache[[]customResource](),
+		clusterInformerFactory: clusterInformerFactory,
+		namespaceLister:        clusterInformerFactory.Core().V1().Namespaces().Lister(),
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	// The handlers are added once all informers are set up by the options
+	if m.nodeLister != nil {
+		m.watchConfig()
+	}
+
+	return m
 }
 
-// NewInjector returns *Injector with k8sclient and configMapName
-func NewInjector(k8sclient k8sclient.Interface, namespace string, configMapName string) *Injector {
-	return &Injector{k8sclient, namespace, configMapName}
+// Start starts the informers of the Injector and blocks until their caches
+// are synced or stopCh is closed
+func (m *Injector) Start(stopCh <-chan struct{}) error {
+	synced := []cache.InformerSynced{
+		m.informerFactory.Core().V1().ConfigMaps().Informer().HasSynced,
+		m.clusterInformerFactory.Core().V1().Namespaces().Informer().HasSynced,
+	}
+
+	if m.dynamicInformerFactory != nil {
+		synced = append(synced, m.dynamicInformerFactory.ForResource(v1alpha1.NamespaceAffinityPolicyResource).Informer().HasSynced)
+		m.dynamicInformerFactory.Start(stopCh)
+	}
+
+	if m.nodeLister != nil {
+		synced = append(synced, m.nodesSynced)
+		m.startEvents(stopCh)
+	}
+
+	m.informerFactory.Start(stopCh)
+	m.clusterInformerFactory.Start(stopCh)
+
+	if !cache.WaitForCacheSync(stopCh, synced...) {
+		return ErrCacheSyncFailed
+	}
+
+	m.started.Store(true)
+
+	// The config is checked once all caches are synced, as the config changes
+	// while syncing are ignored
+	if m.nodeLister != nil {
+		m.checkNamespaces()
+	}
+
+	return nil
 }
 
 // Mutate unmarshalls the AdmissionReview (body) and creates or updates the
@@ -91,66 +205,128 @@ func NewInjector(k8sclient k8sclient.Interface, namespace string, configMapName
 func (m *Injector) Mutate(body []byte) ([]byte, error) {
 	log.Infof("Received AdmissionReview: %s\n", string(body))
 
-	// unmarshal request into AdmissionReview struct
-	admissionReview := v1beta1.AdmissionReview{}
-	if err := jsonUnmarshal(body, &admissionReview); err != nil {
-		return nil, fmt.Errorf("%w: %s", ErrInvalidAdmissionReview, err)
+	// unmarshal request into the AdmissionReview for its apiVersion
+	admissionReview, err := decodeAdmissionReview(body)
+	if err != nil {
+		return nil, err
 	}
 
-	var pod *corev1.Pod
-
-	req := admissionReview.Request
+	req := admissionReview.request
 	if req == nil {
 		log.Warning("admissionReview with empty request")
 		return nil, nil
 	}
 
-	resp := v1beta1.AdmissionResponse{}
+	// The pod templates and the pod specs of the workloads and the custom
+	// resources are patched like pods with the paths of the patches rebased
+	// to their location in the object
+	targets, err := m.podTargets(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(targets) == 0 {
+		log.Infof("Ignoring %s %s in namespace: %s", req.Kind.Kind, req.Name, req.Namespace)
+		return admissionReview.respond(mutateWebhook, allowedResponse(req))
+	}
 
-	if err := jsonUnmarshal(req.Object.Raw, &pod); err != nil {
-		return nil, fmt.Errorf("%w: %v", ErrInvalidAdmissionReviewObj, err)
+	if isDryRun(req) {
+		log.Infof("Dry run request: %s", req.UID)
 	}
 
 	// set response options
-	resp.Allowed = true
-	resp.UID = req.UID
-	jsonPatch := v1beta1.PatchTypeJSONPatch
-	resp.PatchType = &jsonPatch
+	resp := admissionv1.AdmissionResponse{
+		Allowed: true,
+		UID:     req.UID,
+	}
 
 	podNamespace := req.Namespace
 	if podNamespace == "" {
 		podNamespace = "default"
 	}
 
-	config, err := m.configForNamespace(podNamespace)
+	config, source, err := m.configForNamespace(podNamespace)
 	if err != nil {
 		return nil, err
 	}
 
-	if ignorePodWithLabels(pod.Labels, config) {
-		log.Infof("Ignoring pod with labels: %#v in namespace: %s", pod.Labels, podNamespace)
-		// return the unmodified AdmissionReview
-		return body, nil
-	}
+	// changes are the patches of all targets before rebasing them, which
+	// are summarised in the warnings
+	var changes, patches []JSONPatch
+	annotated := map[string]bool{}
+	for _, target := range targets {
+		targetPatches, applied, err := patchTarget(&resp, config, target, podNamespace)
+		if err != nil {
+			return nil, err
+		}
 
-	patch, err := buildPatch(config, pod.Spec)
-	if err != nil {
-		return nil, err
-	}
+		if !resp.Allowed {
+			break
+		}
 
-	resp.Patch = patch
+		if applied == nil {
+			continue
+		}
+
+		changes = append(changes, targetPatches...)
+
+		if m.nodeLister != nil {
+			resp.Warnings, err = m.checkNodes(resp.Warnings, config, target.pod, podNamespace, isDryRun(req))
+			if err != nil {
+				return nil, err
+			}
+		}
 
-	resp.AuditAnnotations = map[string]string{
-		annotationKey: string(patch),
+		// Pods in audit mode are not patched, so the applied config is not
+		// recorded. The pod specs of a custom resource share the metadata of
+		// the object, so their config is recorded once
+		if config.Mode != v1alpha1.ModeAudit && !annotated[target.metadataRoot] {
+			annotated[target.metadataRoot] = true
+			applied.configSource = source
+
+			annotationsPatches, err := buildConfigAnnotationsPatches(target.pod, applied)
+			if err != nil {
+				return nil, err
+			}
+			targetPatches = append(targetPatches, annotationsPatches...)
+		}
+
+		patches = append(patches, target.rebase(targetPatches)...)
 	}
 
-	resp.Result = &metav1.Status{
-		Status: successStatus,
+	if resp.Allowed {
+		// The changes in audit mode are reported by auditResponse
+		if config.Mode != v1alpha1.ModeAudit {
+			if warning, ok := patchWarning(podNamespace, changes); ok {
+				resp.Warnings = append(resp.Warnings, warning)
+			}
+		}
+
+		if len(patches) > 0 {
+			patch, err := marshalPatches(patches)
+			if err != nil {
+				return nil, err
+			}
+
+			jsonPatch := admissionv1.PatchTypeJSONPatch
+			resp.PatchType = &jsonPatch
+			resp.Patch = patch
+
+			resp.AuditAnnotations = map[string]string{
+				annotationKey: string(patch),
+			}
+		}
+
+		resp.Result = &metav1.Status{
+			Status: successStatus,
+		}
 	}
 
-	admissionReview.Response = &resp
+	if config.Mode == v1alpha1.ModeAudit {
+		auditResponse(&resp, podNamespace)
+	}
 
-	responseBody, err := jsonMarshal(admissionReview)
+	responseBody, err := admissionReview.respond(mutateWebhook, &resp)
 	if err != nil {
 		return nil, err
 	}
@@ -160,29 +336,94 @@ func (m *Injector) Mutate(body []byte) ([]byte, error) {
 	return responseBody, nil
 }
 
-func (m *Injector) configForNamespace(namespace string) (*NamespaceConfig, error) {
-	configMap, err := m.clientset.CoreV1().
-		ConfigMaps(m.namespace).
-		Get(context.Background(), m.configMapName, metav1.GetOptions{})
+// patchTarget returns the patches for the pod of target, relative to the pod,
+// and the config applied to it, without its source. config is the config for
+// namespace before applying the rules. The warnings about the pod are added to
+// resp and resp is denied when the pod is denied. No config is returned for
+// the pods which are not patched
+func patchTarget(resp *admissionv1.AdmissionResponse, config *NamespaceConfig, target podTarget, namespace string) ([]JSONPatch, *appliedConfig, error) {
+	pod := target.pod
 
+	ignore, err := ignorePod(pod, config)
 	if err != nil {
-		return nil, fmt.Errorf("%w: %s", ErrMissingConfiguration, err)
+		return nil, nil, err
 	}
 
-	namespaceConfigString, exists := configMap.Data[namespace]
-	if !exists {
-		return nil, fmt.Errorf("%w: for %s", ErrMissingConfiguration, namespace)
+	if ignore {
+		log.Infof("Ignoring excluded pod with labels: %#v in namespace: %s", pod.Labels, namespace)
+		return nil, nil, nil
 	}
 
-	config := &NamespaceConfig{}
-	err = yamlUnmarshal([]byte(namespaceConfigString), config)
+	rules, err := ruleNames(config, pod.Labels)
 	if err != nil {
-		return nil, fmt.Errorf("%w: %s", ErrInvalidConfiguration, err)
-	} else if config.NodeSelectorTerms == nil && config.PreferredNodeSelectorTerms == nil && config.Tolerations == nil {
-		return nil, fmt.Errorf("%w: at least one of nodeSelectorTerms, preferredNodeSelectorTerms or tolerations needs to be specified for %s", ErrInvalidConfiguration, namespace)
+		return nil, nil, err
 	}
 
-	return config, nil
+	// The rules are applied before checking for conflicts as the rules can
+	// set the node affinity
+	config, err = applyRules(config, pod.Labels)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The webhook can be reinvoked for a pod it has already patched when
+	// the reinvocationPolicy is IfNeeded
+	hash, err := configHash(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if configApplied(pod, config, hash) {
+		log.Infof("Ignoring pod with the configuration for namespace: %s already applied", namespace)
+		return nil, nil, nil
+	}
+
+	if hasNodeAffinityConflict(config, pod.Spec) {
+		if warning, ok := nodeAffinityConflictWarning(namespace, config.ConflictStrategy, config.NodeSelectorTermsStrategy); ok && config.Mode != v1alpha1.ModeAudit {
+			resp.Warnings = append(resp.Warnings, warning)
+		}
+
+		switch config.ConflictStrategy {
+		case v1alpha1.ConflictStrategySkip:
+			log.Infof("Ignoring pod with node affinity: %#v in namespace: %s", pod.Spec.Affinity.NodeAffinity, namespace)
+			return nil, nil, nil
+		case v1alpha1.ConflictStrategyReject:
+			log.Infof("Rejecting pod with node affinity: %#v in namespace: %s", pod.Spec.Affinity.NodeAffinity, namespace)
+			resp.Allowed = false
+			resp.Result = conflictStatus(namespace)
+			return nil, nil, nil
+		}
+	}
+
+	if conflicts := nodeSelectorConflicts(config, pod.Spec); len(conflicts) > 0 {
+		if config.NodeSelectorConflictPolicy == v1alpha1.NodeSelectorConflictPolicyReport {
+			warning := nodeSelectorConflictMessage(namespace, conflicts)
+			log.Warning(warning)
+			resp.Warnings = append(resp.Warnings, warning)
+		} else {
+			log.Infof("Rejecting pod with nodeSelector: %#v in namespace: %s", pod.Spec.NodeSelector, namespace)
+			resp.Allowed = false
+			resp.Result = nodeSelectorConflictStatus(namespace, conflicts)
+			return nil, nil, nil
+		}
+	}
+
+	patches, err := buildPatches(config, pod)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return patches, &appliedConfig{Rules: rules, Hash: hash}, nil
+}
+
+// allowedResponse returns the response admitting the object in req without
+// changes
+func allowedResponse(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		Allowed: true,
+		UID:     req.UID,
+		Result:  &metav1.Status{Status: successStatus},
+	}
 }
 
 func buildNodeSelectorTermsPath(podSpec corev1.PodSpec) PatchPath {
@@ -204,13 +445,6 @@ func buildNodeSelectorTermsPath(podSpec corev1.PodSpec) PatchPath {
 	return path
 }
 
-func buildTolerationsPath(podSpec corev1.PodSpec) PatchPath {
-	if podSpec.Tolerations == nil {
-		return CreateTolerations
-	}
-	return AddTolerations
-}
-
 func buildPreferredAffinityPath(podSpec corev1.PodSpec) PatchPath {
 	if podSpec.Affinity == nil {
 		return CreateAffinity
@@ -232,102 +466,97 @@ func buildPreferredAffinityPatch(path PatchPath, preferredTerm corev1.PreferredS
 	return patch
 }
 
-// Returns a patch that initialises the PodSpec's PreferredDuringSchedulingIgnoredDuringExecution array as an empty array, if it does not exist
-func buildPreferredAffinityInitPatch(podSpec corev1.PodSpec) (JSONPatch, error) {
-	path := buildPreferredAffinityPath(podSpec)
-
+func buildNodeSelectorTermPatch(path PatchPath, nodeSelectorTerm corev1.NodeSelectorTerm) JSONPatch {
 	patch := JSONPatch{
-		Op:   "add",
-		Path: path,
+		Op:    "add",
+		Path:  path,
+		Value: nodeSelectorTerm,
 	}
 
-	patchAffinity := &corev1.Affinity{
-		NodeAffinity: &corev1.NodeAffinity{
-			PreferredDuringSchedulingIgnoredDuringExecution: []corev1.PreferredSchedulingTerm{},
-		},
-	}
+	return patch
+}
 
-	switch path {
-	case AddToPreferredNodeSelectorTerms:
-		// Array for PreferredDuringSchedulingIgnoredDuringExecution already exists. Do nothing
-		return JSONPatch{}, nil
-	case AddPreferredNodeSelectorTerms:
-		// PreferredDuringSchedulingIgnoredDuringExecution array missing, add it
-		patch.Value = patchAffinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution
-	case CreateNodeAffinity:
-		// Adds NodeAffinity with PreferredDuringSchedulingIgnoredDuringExecution
-		patch.Value = patchAffinity.NodeAffinity
-	case CreateAffinity:
-		// Adds Affinity with NodeAffinity and PreferredDuringSchedulingIgnoredDuringExecution
-		patch.Value = patchAffinity
-	default:
-		return JSONPatch{}, fmt.Errorf("%w: invalid patch path", ErrFailedToCreatePatch)
+// podNodeSelectorTerms returns the required nodeSelectorTerms of podSpec
+func podNodeSelectorTerms(podSpec corev1.PodSpec) []corev1.NodeSelectorTerm {
+	if podSpec.Affinity == nil || podSpec.Affinity.NodeAffinity == nil ||
+		podSpec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return nil
 	}
 
-	return patch, nil
+	return podSpec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
 }
 
-func buildNodeSelectorTermPatch(path PatchPath, nodeSelectorTerm corev1.NodeSelectorTerm) JSONPatch {
-	patch := JSONPatch{
-		Op:    "add",
-		Path:  path,
-		Value: nodeSelectorTerm,
+// buildEnforcedNodeSelectorTermsPatch returns a patch replacing the
+// nodeSelectorTerms of podSpec with every combination of a term of the pod and
+// a term of nodeSelectorTerms. As the terms are ORed and the requirements of
+// each term are ANDed, the pod can only be scheduled on nodes matching both
+// its own terms and nodeSelectorTerms
+func buildEnforcedNodeSelectorTermsPatch(podSpec corev1.PodSpec, nodeSelectorTerms []corev1.NodeSelectorTerm) JSONPatch {
+	podTerms := podNodeSelectorTerms(podSpec)
+
+	terms := make([]corev1.NodeSelectorTerm, 0, len(podTerms)*len(nodeSelectorTerms))
+	for _, podTerm := range podTerms {
+		if len(podTerm.MatchExpressions) == 0 && len(podTerm.MatchFields) == 0 {
+			// An empty term matches no nodes and has to stay that way
+			terms = append(terms, podTerm)
+			continue
+		}
+
+		for _, term := range nodeSelectorTerms {
+			terms = append(terms, corev1.NodeSelectorTerm{
+				MatchExpressions: concat(podTerm.MatchExpressions, term.MatchExpressions),
+				MatchFields:      concat(podTerm.MatchFields, term.MatchFields),
+			})
+		}
 	}
 
-	return patch
+	return JSONPatch{
+		Op:    "replace",
+		Path:  AddNodeSelectorTerms,
+		Value: terms,
+	}
 }
 
-// Returns a patch that initialises the PodSpec's NodeSelectorTerms array as an empty array, if it does not exist
-func buildNodeSelectorTermsInitPatch(podSpec corev1.PodSpec) (JSONPatch, error) {
-	path := buildNodeSelectorTermsPath(podSpec)
+func buildPatch(config *NamespaceConfig, pod *corev1.Pod) ([]byte, error) {
+	patches, err := buildPatches(config, pod)
+	if err != nil {
+		return nil, err
+	}
 
-	patch := JSONPatch{
-		Op:   "add",
-		Path: path,
-	}
-
-	patchAffinity := &corev1.Affinity{
-		NodeAffinity: &corev1.NodeAffinity{
-			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
-				NodeSelectorTerms: []corev1.NodeSelectorTerm{},
-			},
-		},
-	}
-
-	switch path {
-	case AddToNodeSelectorTerms:
-		// Array for NodeSelectorTerms already exists.  Do nothing
-		return JSONPatch{}, nil
-	case AddNodeSelectorTerms:
-		// NodeSelectorTerms array missing, add it
-		fmt.Print("buildNodeSelectorTermsInitPatch case AddNodeSelectorTerms\n")
-		patch.Value = patchAffinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
-	case AddRequiredDuringScheduling:
-		// Adds RequiredDuringScheduling with NodeSelectorTerms
-		patch.Value = patchAffinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
-	case CreateNodeAffinity:
-		// Adds NodeAffinity with RequiredDuringScheduling and NodeSelectorTerms
-		patch.Value = patchAffinity.NodeAffinity
-	case CreateAffinity:
-		// Adds Affinity with NodeAffinity, RequiredDuringScheduling, and NodeSelectorTerms
-		patch.Value = patchAffinity
-	default:
-		return JSONPatch{}, fmt.Errorf("%w: invalid patch path", ErrFailedToCreatePatch)
+	return marshalPatches(patches)
+}
+
+func marshalPatches(patches []JSONPatch) ([]byte, error) {
+	patch, err := jsonMarshal(patches)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrFailedToCreatePatch, err)
 	}
 
 	return patch, nil
 }
 
-func buildPatch(config *NamespaceConfig, podSpec corev1.PodSpec) ([]byte, error) {
+func buildPatches(config *NamespaceConfig, pod *corev1.Pod) ([]JSONPatch, error) {
 	var patches []JSONPatch
 
-	if config.NodeSelectorTerms != nil {
-		initPatch, err := buildNodeSelectorTermsInitPatch(podSpec)
-		if err != nil {
-			return nil, err
-		}
-		if (initPatch != JSONPatch{}) {
+	// podSpec tracks the lists initialised by the patches, so the patches
+	// built later do not initialise them again
+	podSpec := *pod.Spec.DeepCopy()
+
+	config, err := applyRules(config, pod.Labels)
+	if err != nil {
+		return nil, err
+	}
+
+	replaceNodeAffinity := config.ConflictStrategy == v1alpha1.ConflictStrategyReplace && hasNodeAffinityConflict(config, podSpec)
+	if replaceNodeAffinity {
+		patches = append(patches, buildReplaceNodeAffinityPatch(config))
+	} else if config.NodeSelectorTerms != nil && config.NodeSelectorTermsStrategy == v1alpha1.NodeSelectorTermsStrategyEnforce &&
+		len(podNodeSelectorTerms(podSpec)) > 0 {
+		patches = append(patches, buildEnforcedNodeSelectorTermsPatch(podSpec, config.NodeSelectorTerms))
+	} else if config.NodeSelectorTerms != nil {
+		if initPatch, ok := buildAffinityListInitPatch(podSpec, nodeAffinityKey, requiredKey); ok {
 			patches = append(patches, initPatch)
+			initialiseAffinityList(&podSpec, nodeAffinityKey, requiredKey)
 		}
 
 		for _, NodeSelectorTerm := range config.NodeSelectorTerms {
@@ -337,13 +566,10 @@ func buildPatch(config *NamespaceConfig, podSpec corev1.PodSpec) ([]byte, error)
 		}
 	}
 
-	if config.PreferredNodeSelectorTerms != nil {
-		initPatch, err := buildPreferredAffinityInitPatch(podSpec)
-		if err != nil {
-			return nil, err
-		}
-		if (initPatch != JSONPatch{}) {
+	if config.PreferredNodeSelectorTerms != nil && !replaceNodeAffinity {
+		if initPatch, ok := buildAffinityListInitPatch(podSpec, nodeAffinityKey, preferredKey); ok {
 			patches = append(patches, initPatch)
+			initialiseAffinityList(&podSpec, nodeAffinityKey, preferredKey)
 		}
 
 		for _, preferredTerm := range config.PreferredNodeSelectorTerms {
@@ -353,38 +579,23 @@ func buildPatch(config *NamespaceConfig, podSpec corev1.PodSpec) ([]byte, error)
 		}
 	}
 
-	if config.Tolerations != nil {
-		tolerationsPatchPath := buildTolerationsPath(podSpec)
-		for _, toleration := range config.Tolerations {
-			tolerationsPatch := JSONPatch{
-				Op:    "add",
-				Path:  tolerationsPatchPath,
-				Value: toleration,
-			}
-
-			patches = append(patches, tolerationsPatch)
-		}
+	if config.PodAffinity != nil || config.PodAntiAffinity != nil {
+		patches = append(patches, buildPodAffinityPatches(&podSpec, config)...)
 	}
 
-	patch, err := jsonMarshal(patches)
-	if err != nil {
-		return nil, fmt.Errorf("%w: %s", ErrFailedToCreatePatch, err)
+	if config.Tolerations != nil {
+		patches = append(patches, buildTolerationsPatches(podSpec, config)...)
 	}
 
-	return patch, nil
-}
-
-func ignorePodWithLabels(podLabels map[string]string, config *NamespaceConfig) bool {
-	if len(config.ExcludedLabels) == 0 {
-		return false
+	if config.NodeSelector != nil {
+		patches = append(patches, buildNodeSelectorPatches(podSpec, config)...)
 	}
 
-	numMatchedLabels := 0
-	for k, v := range config.ExcludedLabels {
-		if podVal, ok := podLabels[k]; ok && podVal == v {
-			numMatchedLabels++
-		}
+	if config.TopologySpreadConstraints != nil {
+		patches = append(patches, buildTopologySpreadConstraintsPatches(pod, config)...)
 	}
 
-	return numMatchedLabels == len(config.ExcludedLabels)
+	patches = append(patches, buildSchedulingPatches(podSpec, config)...)
+
+	return patches, nil
 }
@@ -6,14 +6,31 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/idgenchev/namespace-node-affinity/api/v1alpha1"
 	"github.com/stretchr/testify/assert"
 	v1beta1 "k8s.io/api/admission/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	k8sclient "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
 )
 
+func newTestInjector(t *testing.T, clientset k8sclient.Interface, namespace, configMapName string) *Injector {
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+
+	m := NewInjector(clientset, namespace, configMapName)
+	assert.NoError(t, m.Start(stopCh))
+
+	return m
+}
+
+var v1beta1TypeMeta = metav1.TypeMeta{
+	APIVersion: "admission.k8s.io/v1beta1",
+	Kind:       "AdmissionReview",
+}
+
 func nodeSelectorTerms() []corev1.NodeSelectorTerm {
 	return []corev1.NodeSelectorTerm{
 		{
@@ -148,39 +165,6 @@ func TestBuildNodeSelectorTermPath(t *testing.T) {
 	}
 }
 
-func TestBuildTolerationsPath(t *testing.T) {
-	t.Parallel()
-
-	testCases := []struct {
-		name         string
-		podSpec      corev1.PodSpec
-		expectedPath PatchPath
-	}{
-		{
-			name: "WithTolerations",
-			podSpec: corev1.PodSpec{
-				Tolerations: tolerations(),
-			},
-			expectedPath: AddTolerations,
-		},
-		{
-			name:         "WithoutTolerations",
-			podSpec:      corev1.PodSpec{},
-			expectedPath: CreateTolerations,
-		},
-	}
-
-	for _, tc := range testCases {
-		tc := tc
-		t.Run(tc.name, func(t *testing.T) {
-			t.Parallel()
-
-			path := buildTolerationsPath(tc.podSpec)
-			assert.Equal(t, tc.expectedPath, path)
-		})
-	}
-}
-
 func TestBuildNodeSelectorTermPatch(t *testing.T) {
 	t.Parallel()
 
@@ -277,11 +261,14 @@ func TestBuildNodeSelectorTermsInitPatch(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
 
-			patch, err := buildNodeSelectorTermsInitPatch(tc.podSpec)
-
-			assert.Nil(t, err)
-			assert.Equal(t, tc.expectedPatch, patch)
+			patch, ok := buildAffinityListInitPatch(tc.podSpec, nodeAffinityKey, requiredKey)
+			assert.Equal(t, tc.expectedPatch != JSONPatch{}, ok)
 
+			expected, err := json.Marshal(tc.expectedPatch)
+			assert.NoError(t, err)
+			actual, err := json.Marshal(patch)
+			assert.NoError(t, err)
+			assert.JSONEq(t, string(expected), string(actual))
 		})
 	}
 }
@@ -290,7 +277,7 @@ func TestMutateWithInvalidBody(t *testing.T) {
 	t.Parallel()
 
 	clientset := fake.NewSimpleClientset()
-	m := Injector{clientset, "default", "cm"}
+	m := newTestInjector(t, clientset, "default", "cm")
 
 	body, err := m.Mutate([]byte("invalid"))
 
@@ -302,7 +289,7 @@ func TestMutateWithNoRequest(t *testing.T) {
 	t.Parallel()
 
 	clientset := fake.NewSimpleClientset()
-	m := Injector{clientset, "default", "cm"}
+	m := newTestInjector(t, clientset, "default", "cm")
 
 	admissionReview := []byte("{}")
 
@@ -316,7 +303,7 @@ func TestMutateWithMissingConfigMap(t *testing.T) {
 	t.Parallel()
 
 	clientset := fake.NewSimpleClientset()
-	m := Injector{clientset, "default", "test-cm"}
+	m := newTestInjector(t, clientset, "default", "test-cm")
 
 	admissionReview := v1beta1.AdmissionReview{
 		Request: &v1beta1.AdmissionRequest{
@@ -347,7 +334,7 @@ func TestMutateWithMissingConfigurationForTheNamespace(t *testing.T) {
 		Data: map[string]string{"someconfig": "somevalue"},
 	}
 	clientset := fake.NewSimpleClientset(cm)
-	m := Injector{clientset, deploymentNamespace, "test-cm"}
+	m := newTestInjector(t, clientset, deploymentNamespace, "test-cm")
 
 	admissionReview := v1beta1.AdmissionReview{
 		Request: &v1beta1.AdmissionRequest{
@@ -407,7 +394,7 @@ func TestMutateWithInvalidConfigForNamespace(t *testing.T) {
 				Data: map[string]string{podNamespace: namespaceConfig},
 			}
 			clientset := fake.NewSimpleClientset(cm)
-			m := Injector{clientset, deploymentNamespace, "test-cm"}
+			m := newTestInjector(t, clientset, deploymentNamespace, "test-cm")
 
 			admissionReview := v1beta1.AdmissionReview{
 				Request: &v1beta1.AdmissionRequest{
@@ -442,7 +429,7 @@ func TestMutateWithBuildPatchError(t *testing.T) {
 		Data: map[string]string{podNamespace: namespaceConfig},
 	}
 	clientset := fake.NewSimpleClientset(cm)
-	m := Injector{clientset, deploymentNamespace, "test-cm"}
+	m := newTestInjector(t, clientset, deploymentNamespace, "test-cm")
 
 	admissionReview := v1beta1.AdmissionReview{
 		Request: &v1beta1.AdmissionRequest{
@@ -486,7 +473,7 @@ func TestMutate(t *testing.T) {
 		Data: map[string]string{podNamespace: string(nsConfigJSON)},
 	}
 	clientset := fake.NewSimpleClientset(cm)
-	m := Injector{clientset, deploymentNamespace, "test-cm"}
+	m := newTestInjector(t, clientset, deploymentNamespace, "test-cm")
 
 	samplePod := corev1.Pod{}
 
@@ -504,8 +491,7 @@ func TestMutate(t *testing.T) {
 	body, err := m.Mutate(j)
 	assert.NoError(t, err)
 
-	expectedPatch, err := buildPatch(&nsConfig, samplePod.Spec)
-	assert.NoError(t, err)
+	expectedPatch := mutatePatch(t, &nsConfig, podNamespace, &samplePod)
 
 	jsonPatch := v1beta1.PatchTypeJSONPatch
 	expectedResp := v1beta1.AdmissionResponse{
@@ -514,9 +500,11 @@ func TestMutate(t *testing.T) {
 		Patch:            expectedPatch,
 		AuditAnnotations: map[string]string{annotationKey: string(expectedPatch)},
 		Result:           &metav1.Status{Status: successStatus},
+		Warnings:         []string{"namespace testing-ns added 1 nodeSelectorTerm and 2 tolerations"},
 	}
 
 	expectedAdmissionReview := admissionReview
+	expectedAdmissionReview.TypeMeta = v1beta1TypeMeta
 	expectedAdmissionReview.Response = &expectedResp
 
 	expectedBody, err := json.Marshal(expectedAdmissionReview)
@@ -545,7 +533,7 @@ func TestMutateIgnoresPodsWithExcludedLabels(t *testing.T) {
 		Data: map[string]string{podNamespace: string(nsConfigJSON)},
 	}
 	clientset := fake.NewSimpleClientset(cm)
-	m := Injector{clientset, deploymentNamespace, "test-cm"}
+	m := newTestInjector(t, clientset, deploymentNamespace, "test-cm")
 
 	admissionReview := v1beta1.AdmissionReview{
 		Request: &v1beta1.AdmissionRequest{
@@ -568,8 +556,16 @@ func TestMutateIgnoresPodsWithExcludedLabels(t *testing.T) {
 	body, err := m.Mutate(j)
 	assert.NoError(t, err)
 
+	expectedAdmissionReview := admissionReview
+	expectedAdmissionReview.TypeMeta = v1beta1TypeMeta
+	expectedAdmissionReview.Response = &v1beta1.AdmissionResponse{
+		Allowed: true,
+		Result:  &metav1.Status{Status: successStatus},
+	}
+
+	expectedBody, err := json.Marshal(expectedAdmissionReview)
 	assert.NoError(t, err)
-	assert.Equal(t, j, body)
+	assert.Equal(t, expectedBody, body)
 }
 
 func preferredSchedulingTerms() []corev1.PreferredSchedulingTerm {
@@ -720,13 +716,12 @@ func TestBuildPreferredAffinityInitPatch(t *testing.T) {
 		{
 			name:    "WithNoAffinity",
 			podSpec: podSpecWithNoAffinity,
+			// The empty list is kept, as the affinity types omit it
 			expectedPatch: JSONPatch{
 				Op:   "add",
 				Path: CreateAffinity,
-				Value: &corev1.Affinity{
-					NodeAffinity: &corev1.NodeAffinity{
-						PreferredDuringSchedulingIgnoredDuringExecution: []corev1.PreferredSchedulingTerm{},
-					},
+				Value: map[string]interface{}{
+					nodeAffinityKey: map[string]interface{}{preferredKey: []corev1.PreferredSchedulingTerm{}},
 				},
 			},
 		},
@@ -734,11 +729,9 @@ func TestBuildPreferredAffinityInitPatch(t *testing.T) {
 			name:    "WithNoNodeAffinity",
 			podSpec: podSpecWithNoNodeAffinity,
 			expectedPatch: JSONPatch{
-				Op:   "add",
-				Path: CreateNodeAffinity,
-				Value: &corev1.NodeAffinity{
-					PreferredDuringSchedulingIgnoredDuringExecution: []corev1.PreferredSchedulingTerm{},
-				},
+				Op:    "add",
+				Path:  CreateNodeAffinity,
+				Value: map[string]interface{}{preferredKey: []corev1.PreferredSchedulingTerm{}},
 			},
 		},
 		{
@@ -767,10 +760,14 @@ func TestBuildPreferredAffinityInitPatch(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
 
-			patch, err := buildPreferredAffinityInitPatch(tc.podSpec)
+			patch, ok := buildAffinityListInitPatch(tc.podSpec, nodeAffinityKey, preferredKey)
+			assert.Equal(t, tc.expectedPatch != JSONPatch{}, ok)
 
-			assert.Nil(t, err)
-			assert.Equal(t, tc.expectedPatch, patch)
+			expected, err := json.Marshal(tc.expectedPatch)
+			assert.NoError(t, err)
+			actual, err := json.Marshal(patch)
+			assert.NoError(t, err)
+			assert.JSONEq(t, string(expected), string(actual))
 		})
 	}
 }
@@ -794,7 +791,7 @@ func TestMutateWithPreferredAffinity(t *testing.T) {
 		Data: map[string]string{podNamespace: string(nsConfigJSON)},
 	}
 	clientset := fake.NewSimpleClientset(cm)
-	m := Injector{clientset, deploymentNamespace, "test-cm"}
+	m := newTestInjector(t, clientset, deploymentNamespace, "test-cm")
 
 	samplePod := corev1.Pod{}
 
@@ -812,8 +809,7 @@ func TestMutateWithPreferredAffinity(t *testing.T) {
 	body, err := m.Mutate(j)
 	assert.NoError(t, err)
 
-	expectedPatch, err := buildPatch(&nsConfig, samplePod.Spec)
-	assert.NoError(t, err)
+	expectedPatch := mutatePatch(t, &nsConfig, podNamespace, &samplePod)
 
 	jsonPatch := v1beta1.PatchTypeJSONPatch
 	expectedResp := v1beta1.AdmissionResponse{
@@ -822,9 +818,11 @@ func TestMutateWithPreferredAffinity(t *testing.T) {
 		Patch:            expectedPatch,
 		AuditAnnotations: map[string]string{annotationKey: string(expectedPatch)},
 		Result:           &metav1.Status{Status: successStatus},
+		Warnings:         []string{"namespace testing-ns-preferred added 2 preferred nodeSelectorTerms"},
 	}
 
 	expectedAdmissionReview := admissionReview
+	expectedAdmissionReview.TypeMeta = v1beta1TypeMeta
 	expectedAdmissionReview.Response = &expectedResp
 
 	expectedBody, err := json.Marshal(expectedAdmissionReview)
@@ -853,7 +851,7 @@ func TestMutateWithBothRequiredAndPreferredAffinity(t *testing.T) {
 		Data: map[string]string{podNamespace: string(nsConfigJSON)},
 	}
 	clientset := fake.NewSimpleClientset(cm)
-	m := Injector{clientset, deploymentNamespace, "test-cm"}
+	m := newTestInjector(t, clientset, deploymentNamespace, "test-cm")
 
 	samplePod := corev1.Pod{}
 
@@ -871,8 +869,7 @@ func TestMutateWithBothRequiredAndPreferredAffinity(t *testing.T) {
 	body, err := m.Mutate(j)
 	assert.NoError(t, err)
 
-	expectedPatch, err := buildPatch(&nsConfig, samplePod.Spec)
-	assert.NoError(t, err)
+	expectedPatch := mutatePatch(t, &nsConfig, podNamespace, &samplePod)
 
 	jsonPatch := v1beta1.PatchTypeJSONPatch
 	expectedResp := v1beta1.AdmissionResponse{
@@ -881,9 +878,11 @@ func TestMutateWithBothRequiredAndPreferredAffinity(t *testing.T) {
 		Patch:            expectedPatch,
 		AuditAnnotations: map[string]string{annotationKey: string(expectedPatch)},
 		Result:           &metav1.Status{Status: successStatus},
+		Warnings:         []string{"namespace testing-ns-both added 1 nodeSelectorTerm, 2 preferred nodeSelectorTerms and 2 tolerations"},
 	}
 
 	expectedAdmissionReview := admissionReview
+	expectedAdmissionReview.TypeMeta = v1beta1TypeMeta
 	expectedAdmissionReview.Response = &expectedResp
 
 	expectedBody, err := json.Marshal(expectedAdmissionReview)
@@ -894,18 +893,18 @@ func TestMutateWithBothRequiredAndPreferredAffinity(t *testing.T) {
 func TestBuildPatchWithPreferredAffinityInitError(t *testing.T) {
 	t.Parallel()
 
-	// Test that buildPatch properly handles errors from buildPreferredAffinityInitPatch
+	// Test that buildPatch initialises the preferred terms of a pod without affinity.
 	// Since buildPreferredAffinityPath always returns valid paths in the current implementation,
-	// we verify that the error handling code path exists and buildPatch can handle it correctly.
+	// we verify that buildPatch creates the patches correctly.
 	config := &NamespaceConfig{
 		PreferredNodeSelectorTerms: preferredSchedulingTerms(),
 	}
 
-	// Create a PodSpec - this should succeed normally as buildPreferredAffinityPath
+	// Create a Pod - this should succeed normally as buildPreferredAffinityPath
 	// always returns valid paths
-	podSpec := corev1.PodSpec{}
+	pod := &corev1.Pod{}
 
-	patch, err := buildPatch(config, podSpec)
+	patch, err := buildPatch(config, pod)
 	assert.NoError(t, err)
 	assert.NotNil(t, patch)
 
@@ -915,3 +914,138 @@ func TestBuildPatchWithPreferredAffinityInitError(t *testing.T) {
 	assert.NoError(t, err)
 	assert.True(t, len(patches) > 0, "Expected at least one patch to be created")
 }
+
+func TestBuildPatchWithEnforcedNodeSelectorTerms(t *testing.T) {
+	t.Parallel()
+
+	zoneTerm := corev1.NodeSelectorTerm{
+		MatchExpressions: []corev1.NodeSelectorRequirement{
+			{
+				Key:      "zone",
+				Operator: corev1.NodeSelectorOpIn,
+				Values:   []string{"a"},
+			},
+		},
+	}
+	poolTerm := corev1.NodeSelectorTerm{
+		MatchFields: []corev1.NodeSelectorRequirement{
+			{
+				Key:      "metadata.name",
+				Operator: corev1.NodeSelectorOpIn,
+				Values:   []string{"node-a"},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name            string
+		podSpec         corev1.PodSpec
+		config          *NamespaceConfig
+		expectedPatches []JSONPatch
+	}{
+		{
+			name:    "PodWithoutTermsUsesAppend",
+			podSpec: podSpecWithNoAffinity,
+			config: &NamespaceConfig{
+				NodeSelectorTerms:         nodeSelectorTerms(),
+				NodeSelectorTermsStrategy: v1alpha1.NodeSelectorTermsStrategyEnforce,
+			},
+			expectedPatches: []JSONPatch{
+				{
+					Op:   "add",
+					Path: CreateAffinity,
+					Value: &corev1.Affinity{
+						NodeAffinity: &corev1.NodeAffinity{
+							RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+								NodeSelectorTerms: []corev1.NodeSelectorTerm{},
+							},
+						},
+					},
+				},
+				{Op: "add", Path: AddToNodeSelectorTerms, Value: nodeSelectorTerms()[0]},
+			},
+		},
+		{
+			name:    "PodWithTermsAndAppend",
+			podSpec: podSpecWithExistingNodeSelectorTerms,
+			config: &NamespaceConfig{
+				NodeSelectorTerms:         []corev1.NodeSelectorTerm{zoneTerm},
+				NodeSelectorTermsStrategy: v1alpha1.NodeSelectorTermsStrategyAppend,
+			},
+			expectedPatches: []JSONPatch{
+				{Op: "add", Path: AddToNodeSelectorTerms, Value: zoneTerm},
+			},
+		},
+		{
+			name:    "PodWithTerms",
+			podSpec: podSpecWithExistingNodeSelectorTerms,
+			config: &NamespaceConfig{
+				NodeSelectorTerms:         []corev1.NodeSelectorTerm{zoneTerm},
+				NodeSelectorTermsStrategy: v1alpha1.NodeSelectorTermsStrategyEnforce,
+			},
+			expectedPatches: []JSONPatch{
+				{
+					Op:   "replace",
+					Path: AddNodeSelectorTerms,
+					Value: []corev1.NodeSelectorTerm{
+						{
+							MatchExpressions: append(nodeSelectorTerms()[0].MatchExpressions, zoneTerm.MatchExpressions...),
+						},
+					},
+				},
+			},
+		},
+		{
+			name:    "EveryCombinationOfTerms",
+			podSpec: podSpecWithExistingNodeSelectorTerms,
+			config: &NamespaceConfig{
+				NodeSelectorTerms:         []corev1.NodeSelectorTerm{zoneTerm, poolTerm},
+				NodeSelectorTermsStrategy: v1alpha1.NodeSelectorTermsStrategyEnforce,
+			},
+			expectedPatches: []JSONPatch{
+				{
+					Op:   "replace",
+					Path: AddNodeSelectorTerms,
+					Value: []corev1.NodeSelectorTerm{
+						{
+							MatchExpressions: append(nodeSelectorTerms()[0].MatchExpressions, zoneTerm.MatchExpressions...),
+						},
+						{
+							MatchExpressions: nodeSelectorTerms()[0].MatchExpressions,
+							MatchFields:      poolTerm.MatchFields,
+						},
+					},
+				},
+			},
+		},
+		{
+			name:    "EmptyPodTermIsKept",
+			podSpec: podSpecWithEmptyNodeSelectorTerms,
+			config: &NamespaceConfig{
+				NodeSelectorTerms:         []corev1.NodeSelectorTerm{zoneTerm},
+				NodeSelectorTermsStrategy: v1alpha1.NodeSelectorTermsStrategyEnforce,
+			},
+			expectedPatches: []JSONPatch{
+				{
+					Op:    "replace",
+					Path:  AddNodeSelectorTerms,
+					Value: podSpecWithEmptyNodeSelectorTerms.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms,
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			patch, err := buildPatch(tc.config, &corev1.Pod{Spec: tc.podSpec})
+			assert.NoError(t, err)
+
+			expectedPatch, err := json.Marshal(tc.expectedPatches)
+			assert.NoError(t, err)
+			assert.JSONEq(t, string(expectedPatch), string(patch))
+		})
+	}
+}
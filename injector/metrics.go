@@ -0,0 +1,50 @@
+package injector
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// Webhooks served by the Injector
+const (
+	mutateWebhook   = "mutate"
+	validateWebhook = "validate"
+)
+
+// Results of the admission reviews
+const (
+	resultPatched = "patched"
+	resultAllowed = "allowed"
+	resultDenied  = "denied"
+)
+
+var admissionReviews = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "namespace_node_affinity_admission_reviews_total",
+		Help: "The number of admission reviews by webhook, result and whether the request is a dry run.",
+	},
+	[]string{"webhook", "result", "dry_run"},
+)
+
+var unschedulableConfigs = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "namespace_node_affinity_unschedulable_configs_total",
+		Help: "The number of times the configuration of a namespace matched no schedulable node or only nodes with taints which are not tolerated, by reason and check.",
+	},
+	[]string{"reason", "check"},
+)
+
+// observeResponse records resp to req in the metrics of webhook
+func observeResponse(webhook string, req *admissionv1.AdmissionRequest, resp *admissionv1.AdmissionResponse) {
+	result := resultAllowed
+	if !resp.Allowed {
+		result = resultDenied
+	} else if resp.Patch != nil {
+		result = resultPatched
+	}
+
+	admissionReviews.WithLabelValues(webhook, result, strconv.FormatBool(isDryRun(req))).Inc()
+}
@@ -0,0 +1,72 @@
+package injector
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	v1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// TestMutateMetrics is not run in parallel with the other tests, so they
+// don't change the counters while it runs
+func TestMutateMetrics(t *testing.T) {
+	m := newTestInjectorWithConfig(t, map[string]string{
+		"testing-ns": "{nodeSelector: {pool: a}}",
+	})
+
+	dryRun := true
+
+	testCases := []struct {
+		name     string
+		dryRun   *bool
+		pod      *corev1.Pod
+		result   string
+		dryRunLV string
+	}{
+		{
+			name:     "Patched",
+			pod:      &corev1.Pod{},
+			result:   resultPatched,
+			dryRunLV: "false",
+		},
+		{
+			name:     "PatchedDryRun",
+			dryRun:   &dryRun,
+			pod:      &corev1.Pod{},
+			result:   resultPatched,
+			dryRunLV: "true",
+		},
+		{
+			name: "Denied",
+			pod: &corev1.Pod{
+				Spec: corev1.PodSpec{NodeSelector: map[string]string{"pool": "b"}},
+			},
+			result:   resultDenied,
+			dryRunLV: "false",
+		},
+	}
+
+	for _, tc := range testCases {
+		counter := admissionReviews.WithLabelValues(mutateWebhook, tc.result, tc.dryRunLV)
+		before := testutil.ToFloat64(counter)
+
+		admissionReview := v1beta1.AdmissionReview{
+			Request: &v1beta1.AdmissionRequest{
+				Namespace: "testing-ns",
+				DryRun:    tc.dryRun,
+				Object:    runtime.RawExtension{Object: tc.pod},
+			},
+		}
+		j, err := json.Marshal(admissionReview)
+		assert.NoError(t, err)
+
+		_, err = m.Mutate(j)
+		assert.NoError(t, err)
+
+		assert.Equal(t, before+1, testutil.ToFloat64(counter), tc.name)
+	}
+}
@@ -0,0 +1,332 @@
+package injector
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/idgenchev/namespace-node-affinity/api/v1alpha1"
+	"github.com/idgenchev/namespace-node-affinity/webhookconfig"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+// Reasons a configuration cannot be scheduled
+const (
+	reasonNoMatchingNodes   = "no_matching_nodes"
+	reasonUntoleratedTaints = "untolerated_taints"
+)
+
+// Checks of the configurations against the nodes
+const (
+	checkAdmission = "admission"
+	checkConfig    = "config"
+)
+
+const (
+	// eventComponent is the source of the events emitted by the Injector
+	eventComponent = "namespace-node-affinity"
+	// unschedulableEventReason is the reason of the events about the
+	// configurations no node can satisfy
+	unschedulableEventReason = "NoFeasibleNodes"
+)
+
+// enabledNamespaces matches the namespaceSelector of the webhook
+var enabledNamespaces = labels.SelectorFromSet(labels.Set{webhookconfig.NamespaceLabel: webhookconfig.NamespaceLabelEnabled})
+
+// WithNodeChecks makes the Injector read the nodes through an informer and
+// warn about the configurations whose nodeSelectorTerms and nodeSelector match
+// no schedulable node, or only nodes with taints which are not tolerated. The
+// configuration of the pods is checked on admission and the configuration of
+// every enabled namespace whenever the config changes
+func WithNodeChecks() Option {
+	return func(m *Injector) {
+		informer := m.clusterInformerFactory.Core().V1().Nodes()
+		m.nodeLister = informer.Lister()
+		m.nodesSynced = informer.Informer().HasSynced
+
+		m.eventBroadcaster = record.NewBroadcaster()
+		m.recorder = m.eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: eventComponent})
+	}
+}
+
+// watchConfig checks the configuration of the enabled namespaces whenever
+// the ConfigMap or the spec of a NamespaceAffinityPolicy changes
+func (m *Injector) watchConfig() {
+	m.informerFactory.Core().V1().ConfigMaps().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(interface{}) {
+			m.checkNamespaces()
+		},
+		UpdateFunc: func(_, _ interface{}) {
+			m.checkNamespaces()
+		},
+	})
+
+	if m.dynamicInformerFactory == nil {
+		return
+	}
+
+	m.dynamicInformerFactory.ForResource(v1alpha1.NamespaceAffinityPolicyResource).Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(interface{}) {
+			m.checkNamespaces()
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			// The status updates of the policies do not change their spec
+			oldPolicy, oldOk := oldObj.(*unstructured.Unstructured)
+			newPolicy, newOk := newObj.(*unstructured.Unstructured)
+			if oldOk && newOk && oldPolicy.GetGeneration() == newPolicy.GetGeneration() {
+				return
+			}
+			m.checkNamespaces()
+		},
+	})
+}
+
+// startEvents starts sending the events of the Injector to the API server
+// until stopCh is closed
+func (m *Injector) startEvents(stopCh <-chan struct{}) {
+	m.eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: m.clientset.CoreV1().Events("")})
+
+	go func() {
+		<-stopCh
+		m.eventBroadcaster.Shutdown()
+	}()
+}
+
+// checkNamespaces checks the configuration of the enabled namespaces against
+// the nodes. The terms of the pod rules are only checked on admission, as
+// they depend on the labels of the pods
+func (m *Injector) checkNamespaces() {
+	if !m.started.Load() {
+		return
+	}
+
+	namespaces, err := m.namespaceLister.List(enabledNamespaces)
+	if err != nil {
+		log.Warningf("Failed to list the enabled namespaces: %s", err)
+		return
+	}
+
+	nodes, err := m.nodeLister.List(labels.Everything())
+	if err != nil {
+		log.Warningf("Failed to list the nodes: %s", err)
+		return
+	}
+
+	for _, ns := range namespaces {
+		config, _, err := m.configForNamespace(ns.Name)
+		if err != nil {
+			continue
+		}
+
+		if reason, message, ok := unschedulableConfig(nodes, config, nil, ns.Name); ok {
+			m.reportUnschedulable(ns.Name, reason, message, checkConfig)
+		}
+	}
+}
+
+// checkNodes adds a warning to warnings when no node satisfies config for pod
+// in namespace. The metric and the event are skipped for dry runs. The event
+// is only emitted for the first pod with an unschedulable configuration, so
+// the pods of a rollout do not flood the events of the namespace
+func (m *Injector) checkNodes(warnings []string, config *NamespaceConfig, pod *corev1.Pod, namespace string, dryRun bool) ([]string, error) {
+	config, err := applyRules(config, pod.Labels)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := m.nodeLister.List(labels.Everything())
+	if err != nil {
+		log.Warningf("Failed to list the nodes: %s", err)
+		return warnings, nil
+	}
+
+	hash, err := configHash(config)
+	if err != nil {
+		return nil, err
+	}
+	reportedKey := namespace + "/" + hash
+
+	reason, message, ok := unschedulableConfig(nodes, config, pod.Spec.Tolerations, namespace)
+	if !ok {
+		// The configuration is reported again when it becomes unschedulable
+		m.reportedConfigs.Delete(reportedKey)
+		return warnings, nil
+	}
+
+	for _, warning := range warnings {
+		if warning == message {
+			return warnings, nil
+		}
+	}
+
+	if !dryRun {
+		if _, reported := m.reportedConfigs.LoadOrStore(reportedKey, true); reported {
+			unschedulableConfigs.WithLabelValues(reason, checkAdmission).Inc()
+		} else {
+			m.reportUnschedulable(namespace, reason, message, checkAdmission)
+		}
+	}
+
+	return append(warnings, message), nil
+}
+
+// reportUnschedulable records an unschedulable configuration of namespace in
+// the metrics and in a warning event of the namespace
+func (m *Injector) reportUnschedulable(namespace, reason, message, check string) {
+	log.Warning(message)
+	unschedulableConfigs.WithLabelValues(reason, check).Inc()
+
+	ns, err := m.namespaceLister.Get(namespace)
+	if err != nil {
+		ns = &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}}
+	}
+
+	m.recorder.Event(ns, corev1.EventTypeWarning, unschedulableEventReason, message)
+}
+
+// unschedulableConfig reports whether none of nodes satisfies the
+// nodeSelectorTerms and the nodeSelector of config with its tolerations and
+// the extra tolerations, and returns the reason and a message for namespace.
+// Cordoned nodes are not considered and configurations without
+// nodeSelectorTerms or nodeSelector are not checked
+func unschedulableConfig(nodes []*corev1.Node, config *NamespaceConfig, tolerations []corev1.Toleration, namespace string) (string, string, bool) {
+	if len(config.NodeSelectorTerms) == 0 && len(config.NodeSelector) == 0 {
+		return "", "", false
+	}
+
+	tolerations = concat(config.Tolerations, tolerations)
+
+	matching := 0
+	for _, node := range nodes {
+		if node.Spec.Unschedulable || !matchesNode(config, node) {
+			continue
+		}
+
+		if toleratesTaints(tolerations, node.Spec.Taints) {
+			return "", "", false
+		}
+
+		matching++
+	}
+
+	if matching == 0 {
+		return reasonNoMatchingNodes, fmt.Sprintf("no schedulable node matches the nodeSelectorTerms and the nodeSelector of namespace %s", namespace), true
+	}
+
+	return reasonUntoleratedTaints, fmt.Sprintf("the taints of the schedulable nodes matching the nodeSelectorTerms and the nodeSelector of namespace %s are not tolerated", namespace), true
+}
+
+// matchesNode reports whether node has the nodeSelector labels of config and
+// matches any of its nodeSelectorTerms
+func matchesNode(config *NamespaceConfig, node *corev1.Node) bool {
+	for key, value := range config.NodeSelector {
+		if nodeValue, ok := node.Labels[key]; !ok || nodeValue != value {
+			return false
+		}
+	}
+
+	if len(config.NodeSelectorTerms) == 0 {
+		return true
+	}
+
+	for _, term := range config.NodeSelectorTerms {
+		if matchesNodeSelectorTerm(term, node) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchesNodeSelectorTerm reports whether node matches all the requirements
+// of term. Terms without requirements match no nodes
+func matchesNodeSelectorTerm(term corev1.NodeSelectorTerm, node *corev1.Node) bool {
+	if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+		return false
+	}
+
+	for _, requirement := range term.MatchExpressions {
+		if !matchesRequirement(requirement, node.Labels) {
+			return false
+		}
+	}
+
+	// metadata.name is the only field supported by the scheduler
+	for _, requirement := range term.MatchFields {
+		if requirement.Key != "metadata.name" || !matchesRequirement(requirement, map[string]string{requirement.Key: node.Name}) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchesRequirement reports whether values satisfy requirement
+func matchesRequirement(requirement corev1.NodeSelectorRequirement, values map[string]string) bool {
+	value, exists := values[requirement.Key]
+
+	switch requirement.Operator {
+	case corev1.NodeSelectorOpIn:
+		return exists && slices.Contains(requirement.Values, value)
+	case corev1.NodeSelectorOpNotIn:
+		return !exists || !slices.Contains(requirement.Values, value)
+	case corev1.NodeSelectorOpExists:
+		return exists
+	case corev1.NodeSelectorOpDoesNotExist:
+		return !exists
+	case corev1.NodeSelectorOpGt, corev1.NodeSelectorOpLt:
+		if !exists || len(requirement.Values) != 1 {
+			return false
+		}
+
+		actual, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return false
+		}
+
+		expected, err := strconv.ParseInt(requirement.Values[0], 10, 64)
+		if err != nil {
+			return false
+		}
+
+		if requirement.Operator == corev1.NodeSelectorOpGt {
+			return actual > expected
+		}
+		return actual < expected
+	}
+
+	return false
+}
+
+// toleratesTaints reports whether tolerations tolerate all the taints
+// preventing pods from being scheduled
+func toleratesTaints(tolerations []corev1.Toleration, taints []corev1.Taint) bool {
+	for i := range taints {
+		taint := &taints[i]
+		if taint.Effect == corev1.TaintEffectPreferNoSchedule {
+			continue
+		}
+
+		tolerated := false
+		for _, toleration := range tolerations {
+			if toleration.ToleratesTaint(taint) {
+				tolerated = true
+				break
+			}
+		}
+
+		if !tolerated {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,311 @@
+package injector
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/idgenchev/namespace-node-affinity/webhookconfig"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func node(name string, labels map[string]string, taints ...corev1.Taint) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Spec:       corev1.NodeSpec{Taints: taints},
+	}
+}
+
+// newTestInjectorWithNodeChecks returns a started Injector checking the
+// configs in data against the nodes in objects and the recorder of its events
+func newTestInjectorWithNodeChecks(t *testing.T, data map[string]string, objects ...runtime.Object) (*Injector, *record.FakeRecorder) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-cm",
+			Namespace: "ns-node-affinity",
+		},
+		Data: data,
+	}
+
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+
+	m := NewInjector(fake.NewSimpleClientset(append(objects, cm)...), "ns-node-affinity", "test-cm", WithNodeChecks())
+	recorder := record.NewFakeRecorder(10)
+	m.recorder = recorder
+	assert.NoError(t, m.Start(stopCh))
+
+	return m, recorder
+}
+
+func TestUnschedulableConfig(t *testing.T) {
+	t.Parallel()
+
+	dedicated := corev1.Taint{Key: "dedicated", Value: "gpu", Effect: corev1.TaintEffectNoSchedule}
+	preferred := corev1.Taint{Key: "spot", Effect: corev1.TaintEffectPreferNoSchedule}
+
+	cordoned := node("cordoned", map[string]string{"pool": "a"})
+	cordoned.Spec.Unschedulable = true
+
+	testCases := []struct {
+		name           string
+		nodes          []*corev1.Node
+		config         string
+		tolerations    []corev1.Toleration
+		expectedReason string
+	}{
+		{
+			name:   "MatchingNode",
+			nodes:  []*corev1.Node{node("a", map[string]string{"pool": "a"})},
+			config: "{nodeSelectorTerms: [{matchExpressions: [{key: pool, operator: In, values: [a, b]}]}]}",
+		},
+		{
+			name:           "NoMatchingNode",
+			nodes:          []*corev1.Node{node("a", map[string]string{"pool": "a"})},
+			config:         "{nodeSelectorTerms: [{matchExpressions: [{key: pool, operator: In, values: [typo]}]}]}",
+			expectedReason: reasonNoMatchingNodes,
+		},
+		{
+			name:           "CordonedNode",
+			nodes:          []*corev1.Node{cordoned},
+			config:         "{nodeSelector: {pool: a}}",
+			expectedReason: reasonNoMatchingNodes,
+		},
+		{
+			name:           "NodeSelectorAndTerms",
+			nodes:          []*corev1.Node{node("a", map[string]string{"pool": "a"}), node("b", map[string]string{"zone": "b"})},
+			config:         "{nodeSelector: {pool: a}, nodeSelectorTerms: [{matchExpressions: [{key: zone, operator: Exists}]}]}",
+			expectedReason: reasonNoMatchingNodes,
+		},
+		{
+			name:   "MatchFields",
+			nodes:  []*corev1.Node{node("a", nil)},
+			config: "{nodeSelectorTerms: [{matchFields: [{key: metadata.name, operator: In, values: [a]}]}]}",
+		},
+		{
+			name:           "UntoleratedTaint",
+			nodes:          []*corev1.Node{node("a", map[string]string{"pool": "a"}, dedicated)},
+			config:         "{nodeSelector: {pool: a}}",
+			expectedReason: reasonUntoleratedTaints,
+		},
+		{
+			name:   "TaintToleratedByTheConfig",
+			nodes:  []*corev1.Node{node("a", map[string]string{"pool": "a"}, dedicated)},
+			config: "{nodeSelector: {pool: a}, tolerations: [{key: dedicated, operator: Exists}]}",
+		},
+		{
+			name:        "TaintToleratedByThePod",
+			nodes:       []*corev1.Node{node("a", map[string]string{"pool": "a"}, dedicated)},
+			config:      "{nodeSelector: {pool: a}}",
+			tolerations: []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "gpu"}},
+		},
+		{
+			name:   "PreferNoScheduleTaint",
+			nodes:  []*corev1.Node{node("a", map[string]string{"pool": "a"}, preferred)},
+			config: "{nodeSelector: {pool: a}}",
+		},
+		{
+			name:   "NoTermsOrNodeSelector",
+			config: "{tolerations: [{key: dedicated, operator: Exists}]}",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			config, err := parseNamespaceConfig(tc.config)
+			assert.NoError(t, err)
+
+			reason, message, ok := unschedulableConfig(tc.nodes, config, tc.tolerations, "testing-ns")
+			assert.Equal(t, tc.expectedReason != "", ok)
+			assert.Equal(t, tc.expectedReason, reason)
+			if ok {
+				assert.Contains(t, message, "namespace testing-ns")
+			}
+		})
+	}
+}
+
+func TestMatchesRequirement(t *testing.T) {
+	t.Parallel()
+
+	labels := map[string]string{"pool": "a", "cpus": "8"}
+
+	testCases := []struct {
+		name        string
+		requirement corev1.NodeSelectorRequirement
+		expected    bool
+	}{
+		{
+			name:        "In",
+			requirement: corev1.NodeSelectorRequirement{Key: "pool", Operator: corev1.NodeSelectorOpIn, Values: []string{"a", "b"}},
+			expected:    true,
+		},
+		{
+			name:        "InMissingLabel",
+			requirement: corev1.NodeSelectorRequirement{Key: "zone", Operator: corev1.NodeSelectorOpIn, Values: []string{"a"}},
+		},
+		{
+			name:        "NotIn",
+			requirement: corev1.NodeSelectorRequirement{Key: "pool", Operator: corev1.NodeSelectorOpNotIn, Values: []string{"a"}},
+		},
+		{
+			name:        "NotInMissingLabel",
+			requirement: corev1.NodeSelectorRequirement{Key: "zone", Operator: corev1.NodeSelectorOpNotIn, Values: []string{"a"}},
+			expected:    true,
+		},
+		{
+			name:        "Exists",
+			requirement: corev1.NodeSelectorRequirement{Key: "pool", Operator: corev1.NodeSelectorOpExists},
+			expected:    true,
+		},
+		{
+			name:        "DoesNotExist",
+			requirement: corev1.NodeSelectorRequirement{Key: "pool", Operator: corev1.NodeSelectorOpDoesNotExist},
+		},
+		{
+			name:        "Gt",
+			requirement: corev1.NodeSelectorRequirement{Key: "cpus", Operator: corev1.NodeSelectorOpGt, Values: []string{"4"}},
+			expected:    true,
+		},
+		{
+			name:        "Lt",
+			requirement: corev1.NodeSelectorRequirement{Key: "cpus", Operator: corev1.NodeSelectorOpLt, Values: []string{"4"}},
+		},
+		{
+			name:        "GtNotANumber",
+			requirement: corev1.NodeSelectorRequirement{Key: "pool", Operator: corev1.NodeSelectorOpGt, Values: []string{"4"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.expected, matchesRequirement(tc.requirement, labels))
+		})
+	}
+}
+
+func TestMutateWarnsAboutUnschedulableConfig(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		dryRun        bool
+		expectedEvent bool
+	}{
+		{
+			name:          "Request",
+			expectedEvent: true,
+		},
+		{
+			name:   "DryRun",
+			dryRun: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			m, recorder := newTestInjectorWithNodeChecks(t,
+				map[string]string{"testing-ns": "{nodeSelector: {pool: typo}}"},
+				node("a", map[string]string{"pool": "a"}),
+			)
+
+			admissionReview := admissionv1.AdmissionReview{
+				TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+				Request: &admissionv1.AdmissionRequest{
+					Namespace: "testing-ns",
+					DryRun:    &tc.dryRun,
+					Object:    runtime.RawExtension{Object: &corev1.Pod{}},
+				},
+			}
+			j, err := json.Marshal(admissionReview)
+			assert.NoError(t, err)
+
+			body, err := m.Mutate(j)
+			assert.NoError(t, err)
+
+			resp := admissionv1.AdmissionReview{}
+			assert.NoError(t, json.Unmarshal(body, &resp))
+			assert.True(t, resp.Response.Allowed)
+			assert.Contains(t, resp.Response.Warnings, "no schedulable node matches the nodeSelectorTerms and the nodeSelector of namespace testing-ns")
+
+			if tc.expectedEvent {
+				assert.Equal(t, "Warning NoFeasibleNodes no schedulable node matches the nodeSelectorTerms and the nodeSelector of namespace testing-ns", <-recorder.Events)
+			} else {
+				assert.Empty(t, recorder.Events)
+			}
+		})
+	}
+}
+
+func TestMutateReportsUnschedulableConfigOnce(t *testing.T) {
+	t.Parallel()
+
+	m, recorder := newTestInjectorWithNodeChecks(t,
+		map[string]string{"testing-ns": "{nodeSelector: {pool: typo}}"},
+		node("a", map[string]string{"pool": "a"}),
+	)
+
+	admissionReview := admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			Namespace: "testing-ns",
+			Object:    runtime.RawExtension{Object: &corev1.Pod{}},
+		},
+	}
+	j, err := json.Marshal(admissionReview)
+	assert.NoError(t, err)
+
+	// Every pod gets the warning, but the event is only emitted once for
+	// the configuration
+	for i := 0; i < 3; i++ {
+		body, err := m.Mutate(j)
+		assert.NoError(t, err)
+
+		resp := admissionv1.AdmissionReview{}
+		assert.NoError(t, json.Unmarshal(body, &resp))
+		assert.Contains(t, resp.Response.Warnings, "no schedulable node matches the nodeSelectorTerms and the nodeSelector of namespace testing-ns")
+	}
+
+	assert.Len(t, recorder.Events, 1)
+}
+
+func TestCheckNamespaces(t *testing.T) {
+	t.Parallel()
+
+	_, recorder := newTestInjectorWithNodeChecks(t,
+		map[string]string{
+			"testing-ns": "{nodeSelector: {pool: a}}",
+			"other-ns":   "{nodeSelector: {pool: typo}}",
+			"disabled":   "{nodeSelector: {pool: typo}}",
+		},
+		node("a", map[string]string{"pool": "a"}, corev1.Taint{Key: "dedicated", Effect: corev1.TaintEffectNoSchedule}),
+		namespace("testing-ns", map[string]string{webhookconfig.NamespaceLabel: webhookconfig.NamespaceLabelEnabled}),
+		namespace("other-ns", map[string]string{webhookconfig.NamespaceLabel: webhookconfig.NamespaceLabelEnabled}),
+		namespace("disabled", nil),
+	)
+
+	// The config can be checked again by the handler of the ConfigMap
+	events := map[string]bool{}
+	for len(recorder.Events) > 0 {
+		events[<-recorder.Events] = true
+	}
+
+	assert.Equal(t, map[string]bool{
+		"Warning NoFeasibleNodes the taints of the schedulable nodes matching the nodeSelectorTerms and the nodeSelector of namespace testing-ns are not tolerated": true,
+		"Warning NoFeasibleNodes no schedulable node matches the nodeSelectorTerms and the nodeSelector of namespace other-ns":                                      true,
+	}, events)
+}
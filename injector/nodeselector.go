@@ -0,0 +1,85 @@
+package injector
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// jsonPointerEscaper escapes a key for use in a JSON pointer
+// (https://www.rfc-editor.org/rfc/rfc6901)
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// nodeSelectorConflicts returns the sorted keys of the nodeSelector of config
+// which podSpec already has with a different value
+func nodeSelectorConflicts(config *NamespaceConfig, podSpec corev1.PodSpec) []string {
+	var conflicts []string
+	for k, v := range config.NodeSelector {
+		if podVal, ok := podSpec.NodeSelector[k]; ok && podVal != v {
+			conflicts = append(conflicts, k)
+		}
+	}
+
+	sort.Strings(conflicts)
+	return conflicts
+}
+
+// buildNodeSelectorPatches returns the patches adding the keys of the
+// nodeSelector of config podSpec does not have yet. The keys podSpec already
+// has are left unmodified
+func buildNodeSelectorPatches(podSpec corev1.PodSpec, config *NamespaceConfig) []JSONPatch {
+	if podSpec.NodeSelector == nil {
+		return []JSONPatch{
+			{
+				Op:    "add",
+				Path:  CreateNodeSelector,
+				Value: config.NodeSelector,
+			},
+		}
+	}
+
+	var patches []JSONPatch
+	for _, k := range sortedKeys(config.NodeSelector) {
+		if _, ok := podSpec.NodeSelector[k]; ok {
+			continue
+		}
+
+		patches = append(patches, JSONPatch{
+			Op:    "add",
+			Path:  PatchPath(CreateNodeSelector + "/" + jsonPointerEscaper.Replace(k)),
+			Value: config.NodeSelector[k],
+		})
+	}
+
+	return patches
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func nodeSelectorConflictMessage(namespace string, conflicts []string) string {
+	return fmt.Sprintf("the nodeSelector of the pod conflicts with the nodeSelector for namespace %s for keys: %s", namespace, strings.Join(conflicts, ", "))
+}
+
+// nodeSelectorConflictStatus returns the status for denying the admission of
+// a pod with a nodeSelector conflicting with the one for namespace
+func nodeSelectorConflictStatus(namespace string, conflicts []string) *metav1.Status {
+	return &metav1.Status{
+		Status:  metav1.StatusFailure,
+		Message: nodeSelectorConflictMessage(namespace, conflicts),
+		Reason:  metav1.StatusReasonForbidden,
+		Code:    http.StatusForbidden,
+	}
+}
@@ -0,0 +1,149 @@
+package injector
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestNodeSelectorConflicts(t *testing.T) {
+	t.Parallel()
+
+	config := &NamespaceConfig{
+		NodeSelector: map[string]string{"pool": "a", "zone": "b", "os": "linux"},
+	}
+	podSpec := corev1.PodSpec{
+		NodeSelector: map[string]string{"zone": "c", "pool": "b", "os": "linux", "disk": "ssd"},
+	}
+
+	assert.Equal(t, []string{"pool", "zone"}, nodeSelectorConflicts(config, podSpec))
+	assert.Nil(t, nodeSelectorConflicts(config, corev1.PodSpec{}))
+}
+
+func TestBuildNodeSelectorPatches(t *testing.T) {
+	t.Parallel()
+
+	config := &NamespaceConfig{
+		NodeSelector: map[string]string{
+			"kubernetes.io/os": "linux",
+			"pool":             "a",
+			"zone":             "b",
+		},
+	}
+
+	testCases := []struct {
+		name            string
+		podNodeSelector map[string]string
+		expectedPatches []JSONPatch
+	}{
+		{
+			name: "NoNodeSelector",
+			expectedPatches: []JSONPatch{
+				{Op: "add", Path: CreateNodeSelector, Value: config.NodeSelector},
+			},
+		},
+		{
+			name:            "EmptyNodeSelector",
+			podNodeSelector: map[string]string{},
+			expectedPatches: []JSONPatch{
+				{Op: "add", Path: "/spec/nodeSelector/kubernetes.io~1os", Value: "linux"},
+				{Op: "add", Path: "/spec/nodeSelector/pool", Value: "a"},
+				{Op: "add", Path: "/spec/nodeSelector/zone", Value: "b"},
+			},
+		},
+		{
+			name:            "ExistingKeysAreKept",
+			podNodeSelector: map[string]string{"pool": "a", "zone": "c"},
+			expectedPatches: []JSONPatch{
+				{Op: "add", Path: "/spec/nodeSelector/kubernetes.io~1os", Value: "linux"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			patches := buildNodeSelectorPatches(corev1.PodSpec{NodeSelector: tc.podNodeSelector}, config)
+			assert.Equal(t, tc.expectedPatches, patches)
+		})
+	}
+}
+
+func TestMutateWithNodeSelectorConflict(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name             string
+		policy           string
+		expectedAllowed  bool
+		expectedWarnings []string
+		expectedCode     int32
+	}{
+		{
+			name:         "RejectByDefault",
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "Reject",
+			policy:       "reject",
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:            "Report",
+			policy:          "report",
+			expectedAllowed: true,
+			expectedWarnings: []string{
+				"the nodeSelector of the pod conflicts with the nodeSelector for namespace testing-ns for keys: pool",
+				"namespace testing-ns added 1 nodeSelector label",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			config := "{nodeSelector: {pool: a, zone: b}}"
+			if tc.policy != "" {
+				config = "{nodeSelectorConflictPolicy: " + tc.policy + ", nodeSelector: {pool: a, zone: b}}"
+			}
+			m := newTestInjectorWithConfig(t, map[string]string{"testing-ns": config})
+
+			admissionReview := v1beta1.AdmissionReview{
+				Request: &v1beta1.AdmissionRequest{
+					Namespace: "testing-ns",
+					Object: runtime.RawExtension{
+						Object: &corev1.Pod{
+							Spec: corev1.PodSpec{NodeSelector: map[string]string{"pool": "b"}},
+						},
+					},
+				},
+			}
+			j, err := json.Marshal(admissionReview)
+			assert.NoError(t, err)
+
+			body, err := m.Mutate(j)
+			assert.NoError(t, err)
+
+			resp := v1beta1.AdmissionReview{}
+			assert.NoError(t, json.Unmarshal(body, &resp))
+			assert.Equal(t, tc.expectedAllowed, resp.Response.Allowed)
+			assert.Equal(t, tc.expectedWarnings, resp.Response.Warnings)
+			assert.Equal(t, tc.expectedCode, resp.Response.Result.Code)
+
+			if tc.expectedAllowed {
+				assert.JSONEq(t, `[{"op":"add","path":"/spec/nodeSelector/zone","value":"b"}]`, withoutConfigHashPatch(t, resp.Response.Patch))
+			} else {
+				assert.Contains(t, resp.Response.Result.Message, "pool")
+			}
+		})
+	}
+}
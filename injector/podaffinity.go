@@ -0,0 +1,182 @@
+package injector
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Keys of the affinity lists under /spec/affinity
+const (
+	nodeAffinityKey    = "nodeAffinity"
+	podAffinityKey     = "podAffinity"
+	podAntiAffinityKey = "podAntiAffinity"
+	requiredKey        = "requiredDuringSchedulingIgnoredDuringExecution"
+	preferredKey       = "preferredDuringSchedulingIgnoredDuringExecution"
+	// nodeSelectorTermsKey is the list of the required nodeAffinity
+	nodeSelectorTermsKey = "nodeSelectorTerms"
+)
+
+// buildPodAffinityPatches returns the patches adding the podAffinity and
+// podAntiAffinity terms of config to podSpec. The lists created by the
+// returned patches are initialised in podSpec as well
+func buildPodAffinityPatches(podSpec *corev1.PodSpec, config *NamespaceConfig) []JSONPatch {
+	var patches []JSONPatch
+
+	if podAffinity := config.PodAffinity; podAffinity != nil {
+		patches = append(patches, buildAffinityTermsPatches(podSpec, podAffinityKey, requiredKey, podAffinity.RequiredDuringSchedulingIgnoredDuringExecution)...)
+		patches = append(patches, buildAffinityTermsPatches(podSpec, podAffinityKey, preferredKey, podAffinity.PreferredDuringSchedulingIgnoredDuringExecution)...)
+	}
+
+	if podAntiAffinity := config.PodAntiAffinity; podAntiAffinity != nil {
+		patches = append(patches, buildAffinityTermsPatches(podSpec, podAntiAffinityKey, requiredKey, podAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution)...)
+		patches = append(patches, buildAffinityTermsPatches(podSpec, podAntiAffinityKey, preferredKey, podAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution)...)
+	}
+
+	return patches
+}
+
+// buildAffinityTermsPatches returns the patches adding terms to the list at
+// /spec/affinity/<affinityKey>/<listKey>, preceded by a patch initialising
+// the list if podSpec does not have it yet
+func buildAffinityTermsPatches[T any](podSpec *corev1.PodSpec, affinityKey, listKey string, terms []T) []JSONPatch {
+	if len(terms) == 0 {
+		return nil
+	}
+
+	var patches []JSONPatch
+	if initPatch, ok := buildAffinityListInitPatch(*podSpec, affinityKey, listKey); ok {
+		patches = append(patches, initPatch)
+		initialiseAffinityList(podSpec, affinityKey, listKey)
+	}
+
+	path := PatchPath(CreateAffinity + "/" + affinityKey + "/" + listKey + "/-")
+	for _, term := range terms {
+		patches = append(patches, JSONPatch{
+			Op:    "add",
+			Path:  path,
+			Value: term,
+		})
+	}
+
+	return patches
+}
+
+// buildAffinityListInitPatch returns a patch initialising the list at
+// /spec/affinity/<affinityKey>/<listKey> of podSpec as an empty list, creating
+// the missing parents of the list as well. The returned bool is false when
+// podSpec already has the list
+func buildAffinityListInitPatch(podSpec corev1.PodSpec, affinityKey, listKey string) (JSONPatch, bool) {
+	path := string(affinityListPath(podSpec, affinityKey, listKey))
+	if strings.HasSuffix(path, "/-") {
+		return JSONPatch{}, false
+	}
+
+	// The path is the first missing key of the list, which is added with the
+	// keys after it. The values are maps as the lists are omitted from the
+	// affinity types when empty
+	keys := affinityListKeys(affinityKey, listKey)
+	depth := strings.Count(strings.TrimPrefix(path, CreateAffinity), "/")
+
+	var value interface{} = []interface{}{}
+	for i := len(keys) - 1; i >= depth; i-- {
+		value = map[string]interface{}{keys[i]: value}
+	}
+
+	return JSONPatch{Op: "add", Path: PatchPath(path), Value: value}, true
+}
+
+// affinityListKeys returns the keys of the list at
+// /spec/affinity/<affinityKey>/<listKey>. The list of the required
+// nodeAffinity is the nodeSelectorTerms of its node selector
+func affinityListKeys(affinityKey, listKey string) []string {
+	if affinityKey == nodeAffinityKey && listKey == requiredKey {
+		return []string{affinityKey, listKey, nodeSelectorTermsKey}
+	}
+
+	return []string{affinityKey, listKey}
+}
+
+// affinityListPath returns the path adding to the list at
+// /spec/affinity/<affinityKey>/<listKey> of podSpec, or the path of the first
+// missing parent of the list
+func affinityListPath(podSpec corev1.PodSpec, affinityKey, listKey string) PatchPath {
+	switch {
+	case affinityKey == nodeAffinityKey && listKey == requiredKey:
+		return buildNodeSelectorTermsPath(podSpec)
+	case affinityKey == nodeAffinityKey:
+		return buildPreferredAffinityPath(podSpec)
+	case podSpec.Affinity == nil:
+		return CreateAffinity
+	}
+
+	var hasKind, hasList bool
+	if affinityKey == podAffinityKey && podSpec.Affinity.PodAffinity != nil {
+		podAffinity := podSpec.Affinity.PodAffinity
+		hasKind = true
+		hasList = (listKey == requiredKey && podAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil) ||
+			(listKey == preferredKey && podAffinity.PreferredDuringSchedulingIgnoredDuringExecution != nil)
+	} else if affinityKey == podAntiAffinityKey && podSpec.Affinity.PodAntiAffinity != nil {
+		podAntiAffinity := podSpec.Affinity.PodAntiAffinity
+		hasKind = true
+		hasList = (listKey == requiredKey && podAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil) ||
+			(listKey == preferredKey && podAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution != nil)
+	}
+
+	switch {
+	case !hasKind:
+		return PatchPath(CreateAffinity + "/" + affinityKey)
+	case !hasList:
+		return PatchPath(CreateAffinity + "/" + affinityKey + "/" + listKey)
+	}
+
+	return PatchPath(CreateAffinity + "/" + affinityKey + "/" + listKey + "/-")
+}
+
+// initialiseAffinityList initialises the list at
+// /spec/affinity/<affinityKey>/<listKey> of podSpec, and its parents, the
+// same way the patch from buildAffinityListInitPatch does, so the patches
+// built after it do not replace the list. For the required nodeAffinity, the
+// list is the nodeSelectorTerms
+func initialiseAffinityList(podSpec *corev1.PodSpec, affinityKey, listKey string) {
+	if podSpec.Affinity == nil {
+		podSpec.Affinity = &corev1.Affinity{}
+	}
+	affinity := podSpec.Affinity
+
+	switch affinityKey {
+	case nodeAffinityKey:
+		if affinity.NodeAffinity == nil {
+			affinity.NodeAffinity = &corev1.NodeAffinity{}
+		}
+		if listKey == requiredKey && affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+			affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = &corev1.NodeSelector{}
+		}
+		if listKey == requiredKey && affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms == nil {
+			affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms = []corev1.NodeSelectorTerm{}
+		}
+		if listKey == preferredKey && affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution == nil {
+			affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = []corev1.PreferredSchedulingTerm{}
+		}
+	case podAffinityKey:
+		if affinity.PodAffinity == nil {
+			affinity.PodAffinity = &corev1.PodAffinity{}
+		}
+		if listKey == requiredKey && affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+			affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution = []corev1.PodAffinityTerm{}
+		}
+		if listKey == preferredKey && affinity.PodAffinity.PreferredDuringSchedulingIgnoredDuringExecution == nil {
+			affinity.PodAffinity.PreferredDuringSchedulingIgnoredDuringExecution = []corev1.WeightedPodAffinityTerm{}
+		}
+	default:
+		if affinity.PodAntiAffinity == nil {
+			affinity.PodAntiAffinity = &corev1.PodAntiAffinity{}
+		}
+		if listKey == requiredKey && affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+			affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution = []corev1.PodAffinityTerm{}
+		}
+		if listKey == preferredKey && affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution == nil {
+			affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution = []corev1.WeightedPodAffinityTerm{}
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package injector
+
+import (
+	"encoding/json"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func podAffinityTerm(namespace string) corev1.PodAffinityTerm {
+	return corev1.PodAffinityTerm{
+		TopologyKey: "kubernetes.io/hostname",
+		NamespaceSelector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{"kubernetes.io/metadata.name": namespace},
+		},
+		LabelSelector: &metav1.LabelSelector{},
+	}
+}
+
+// applyPatch returns obj with the JSON patch applied to it
+func applyPatch[T any](t *testing.T, obj T, patch []byte) T {
+	decoded, err := jsonpatch.DecodePatch(patch)
+	assert.NoError(t, err)
+
+	j, err := json.Marshal(obj)
+	assert.NoError(t, err)
+
+	patchedJSON, err := decoded.Apply(j)
+	assert.NoError(t, err, "the patch cannot be applied to the object")
+
+	var patched T
+	assert.NoError(t, json.Unmarshal(patchedJSON, &patched))
+
+	return patched
+}
+
+func TestBuildPodAffinityPatches(t *testing.T) {
+	t.Parallel()
+
+	required := podAffinityTerm("team-a")
+	preferred := corev1.WeightedPodAffinityTerm{Weight: 10, PodAffinityTerm: podAffinityTerm("team-b")}
+
+	config := &NamespaceConfig{
+		PodAffinity: &corev1.PodAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{required},
+		},
+		PodAntiAffinity: &corev1.PodAntiAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{preferred},
+		},
+	}
+
+	testCases := []struct {
+		name            string
+		podSpec         corev1.PodSpec
+		expectedPatches []JSONPatch
+	}{
+		{
+			name:    "NoAffinity",
+			podSpec: corev1.PodSpec{},
+			expectedPatches: []JSONPatch{
+				{
+					Op:    "add",
+					Path:  CreateAffinity,
+					Value: map[string]interface{}{podAffinityKey: map[string]interface{}{requiredKey: []interface{}{}}},
+				},
+				{Op: "add", Path: "/spec/affinity/podAffinity/requiredDuringSchedulingIgnoredDuringExecution/-", Value: required},
+				{
+					Op:    "add",
+					Path:  "/spec/affinity/podAntiAffinity",
+					Value: map[string]interface{}{preferredKey: []interface{}{}},
+				},
+				{Op: "add", Path: "/spec/affinity/podAntiAffinity/preferredDuringSchedulingIgnoredDuringExecution/-", Value: preferred},
+			},
+		},
+		{
+			name: "EmptyPodAffinity",
+			podSpec: corev1.PodSpec{Affinity: &corev1.Affinity{
+				PodAffinity:     &corev1.PodAffinity{},
+				PodAntiAffinity: &corev1.PodAntiAffinity{},
+			}},
+			expectedPatches: []JSONPatch{
+				{Op: "add", Path: "/spec/affinity/podAffinity/requiredDuringSchedulingIgnoredDuringExecution", Value: []interface{}{}},
+				{Op: "add", Path: "/spec/affinity/podAffinity/requiredDuringSchedulingIgnoredDuringExecution/-", Value: required},
+				{Op: "add", Path: "/spec/affinity/podAntiAffinity/preferredDuringSchedulingIgnoredDuringExecution", Value: []interface{}{}},
+				{Op: "add", Path: "/spec/affinity/podAntiAffinity/preferredDuringSchedulingIgnoredDuringExecution/-", Value: preferred},
+			},
+		},
+		{
+			name: "ExistingTerms",
+			podSpec: corev1.PodSpec{Affinity: &corev1.Affinity{
+				PodAffinity: &corev1.PodAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{podAffinityTerm("existing")},
+				},
+				PodAntiAffinity: &corev1.PodAntiAffinity{
+					PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{},
+				},
+			}},
+			expectedPatches: []JSONPatch{
+				{Op: "add", Path: "/spec/affinity/podAffinity/requiredDuringSchedulingIgnoredDuringExecution/-", Value: required},
+				{Op: "add", Path: "/spec/affinity/podAntiAffinity/preferredDuringSchedulingIgnoredDuringExecution/-", Value: preferred},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			podSpec := *tc.podSpec.DeepCopy()
+			patches := buildPodAffinityPatches(&podSpec, config)
+			assert.Equal(t, tc.expectedPatches, patches)
+		})
+	}
+}
+
+func TestBuildPatchWithNodeAndPodAffinity(t *testing.T) {
+	t.Parallel()
+
+	config := &NamespaceConfig{
+		NodeSelectorTerms: nodeSelectorTerms(),
+		PodAntiAffinity: &corev1.PodAntiAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{podAffinityTerm("team-a")},
+		},
+	}
+	pod := &corev1.Pod{}
+
+	patch, err := buildPatch(config, pod)
+	assert.NoError(t, err)
+
+	var patches []JSONPatch
+	assert.NoError(t, json.Unmarshal(patch, &patches))
+
+	// Only the first patch creates the affinity, so the node affinity is not
+	// replaced by the podAntiAffinity
+	assert.Len(t, patches, 4)
+	assert.Equal(t, PatchPath(CreateAffinity), patches[0].Path)
+	assert.Equal(t, PatchPath("/spec/affinity/podAntiAffinity"), patches[2].Path)
+	assert.Nil(t, pod.Spec.Affinity, "the pod should not be modified")
+}
+
+func TestAffinityPatchesApply(t *testing.T) {
+	t.Parallel()
+
+	preferred := corev1.WeightedPodAffinityTerm{Weight: 10, PodAffinityTerm: podAffinityTerm("team-b")}
+
+	testCases := []struct {
+		name    string
+		config  *NamespaceConfig
+		podSpec corev1.PodSpec
+	}{
+		{
+			name:   "PreferredNodeAffinity",
+			config: &NamespaceConfig{PreferredNodeSelectorTerms: preferredSchedulingTerms()},
+		},
+		{
+			name:    "PreferredNodeAffinityWithEmptyAffinity",
+			config:  &NamespaceConfig{PreferredNodeSelectorTerms: preferredSchedulingTerms()},
+			podSpec: corev1.PodSpec{Affinity: &corev1.Affinity{}},
+		},
+		{
+			name: "RequiredAndPreferredNodeAffinity",
+			config: &NamespaceConfig{
+				NodeSelectorTerms:          nodeSelectorTerms(),
+				PreferredNodeSelectorTerms: preferredSchedulingTerms(),
+			},
+		},
+		{
+			name: "PreferredNodeAndPodAffinity",
+			config: &NamespaceConfig{
+				PreferredNodeSelectorTerms: preferredSchedulingTerms(),
+				PodAffinity: &corev1.PodAffinity{
+					PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{preferred},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			pod := &corev1.Pod{Spec: tc.podSpec}
+			patch, err := buildPatch(tc.config, pod)
+			assert.NoError(t, err)
+
+			patched := applyPatch(t, pod, patch)
+			nodeAffinity := patched.Spec.Affinity.NodeAffinity
+			assert.Len(t, nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution, len(tc.config.PreferredNodeSelectorTerms))
+			if tc.config.NodeSelectorTerms != nil {
+				assert.Len(t, nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms, len(tc.config.NodeSelectorTerms))
+			}
+			if tc.config.PodAffinity != nil {
+				assert.Len(t, patched.Spec.Affinity.PodAffinity.PreferredDuringSchedulingIgnoredDuringExecution, 1)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package injector
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/idgenchev/namespace-node-affinity/api/v1alpha1"
+	log "github.com/sirupsen/logrus"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// WithPolicies makes the Injector read NamespaceAffinityPolicy objects through
+// dynamicClient in addition to the ConfigMap and keep their status up to date.
+// The NamespaceAffinityPolicy CRD must be installed in the cluster
+func WithPolicies(dynamicClient dynamic.Interface) Option {
+	return func(m *Injector) {
+		m.dynamicClient = dynamicClient
+		m.dynamicInformerFactory = dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, 0)
+		m.policyCache = newConfigCache[*NamespaceConfig]()
+
+		informer := m.dynamicInformerFactory.ForResource(v1alpha1.NamespaceAffinityPolicyResource)
+		m.policyLister = informer.Lister()
+
+		informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: m.updatePolicyStatus,
+			UpdateFunc: func(_, obj interface{}) {
+				m.updatePolicyStatus(obj)
+			},
+			DeleteFunc: func(obj interface{}) {
+				if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				if u, ok := obj.(*unstructured.Unstructured); ok {
+					m.policyCache.delete(u.GetName())
+				}
+			},
+		})
+	}
+}
+
+// policyForNamespace returns the spec of the NamespaceAffinityPolicy with the
+// same name as namespace. The returned bool reports whether such a policy
+// exists
+func (m *Injector) policyForNamespace(namespace string) (*NamespaceConfig, bool, error) {
+	obj, err := m.policyLister.Get(namespace)
+	if k8serrors.IsNotFound(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, fmt.Errorf("%w: %s", ErrMissingConfiguration, err)
+	}
+
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, true, fmt.Errorf("%w: unexpected object %T for NamespaceAffinityPolicy %s", ErrInvalidConfiguration, obj, namespace)
+	}
+
+	config, err := m.policyConfig(u)
+	return config, true, err
+}
+
+// policyConfig returns the spec of the NamespaceAffinityPolicy in u
+func (m *Injector) policyConfig(u *unstructured.Unstructured) (*NamespaceConfig, error) {
+	if config, ok := m.policyCache.get(u.GetName(), u.GetResourceVersion()); ok {
+		return config, nil
+	}
+
+	policy, err := policyFromUnstructured(u)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &policy.Spec
+	m.policyCache.set(u.GetName(), u.GetResourceVersion(), config)
+
+	return config, nil
+}
+
+func policyFromUnstructured(u *unstructured.Unstructured) (*v1alpha1.NamespaceAffinityPolicy, error) {
+	policy := &v1alpha1.NamespaceAffinityPolicy{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), policy); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidConfiguration, err)
+	}
+
+	return policy, nil
+}
+
+// validatePolicy validates the spec of a NamespaceAffinityPolicy with the
+// profiles it uses. Policies extending the default entry are only complete
+// once merged, so they are not required to have terms or tolerations of their
+// own
+func (m *Injector) validatePolicy(name string, spec *NamespaceConfig) error {
+	config, err := m.resolveProfiles(spec)
+	if err != nil {
+		return err
+	}
+
+	if spec.MergeDefault != nil && *spec.MergeDefault {
+		return nil
+	}
+
+	return validateNamespaceConfig(name, config)
+}
+
+// updatePolicyStatus sets the Valid condition of the NamespaceAffinityPolicy
+// in obj. The status is only updated when the condition or the observed
+// generation changes
+func (m *Injector) updatePolicyStatus(obj interface{}) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+
+	condition := metav1.Condition{
+		Type:               v1alpha1.ConditionValid,
+		Status:             metav1.ConditionTrue,
+		Reason:             v1alpha1.ReasonValid,
+		ObservedGeneration: u.GetGeneration(),
+	}
+
+	policy, err := policyFromUnstructured(u)
+	if err == nil {
+		err = m.validatePolicy(u.GetName(), &policy.Spec)
+	}
+	if err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = v1alpha1.ReasonInvalidConfiguration
+		condition.Message = err.Error()
+	}
+
+	// The status is decoded on its own as the spec might not be decodable
+	policyStatus := v1alpha1.NamespaceAffinityPolicyStatus{}
+	if rawStatus, ok := u.Object["status"].(map[string]interface{}); ok {
+		_ = runtime.DefaultUnstructuredConverter.FromUnstructured(rawStatus, &policyStatus)
+	}
+
+	existing := meta.FindStatusCondition(policyStatus.Conditions, v1alpha1.ConditionValid)
+	if policyStatus.ObservedGeneration == u.GetGeneration() && existing != nil &&
+		existing.Status == condition.Status && existing.Message == condition.Message {
+		return
+	}
+
+	policyStatus.ObservedGeneration = u.GetGeneration()
+	meta.SetStatusCondition(&policyStatus.Conditions, condition)
+
+	status, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&policyStatus)
+	if err != nil {
+		log.Errorf("Failed to convert the status of NamespaceAffinityPolicy %s: %s", u.GetName(), err)
+		return
+	}
+
+	updated := u.DeepCopy()
+	updated.Object["status"] = status
+
+	_, err = m.dynamicClient.Resource(v1alpha1.NamespaceAffinityPolicyResource).
+		UpdateStatus(context.Background(), updated, metav1.UpdateOptions{})
+	if err != nil {
+		log.Warningf("Failed to update the status of NamespaceAffinityPolicy %s: %s", u.GetName(), err)
+	}
+}
@@ -0,0 +1,169 @@
+package injector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/idgenchev/namespace-node-affinity/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func policy(t *testing.T, name string, spec NamespaceConfig) *unstructured.Unstructured {
+	p := &v1alpha1.NamespaceAffinityPolicy{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1alpha1.GroupVersion.String(),
+			Kind:       v1alpha1.NamespaceAffinityPolicyKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Generation:      1,
+			ResourceVersion: "1",
+		},
+		Spec: spec,
+	}
+
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(p)
+	assert.NoError(t, err)
+
+	return &unstructured.Unstructured{Object: obj}
+}
+
+func newTestDynamicClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			v1alpha1.NamespaceAffinityPolicyResource: v1alpha1.NamespaceAffinityPolicyListKind,
+		},
+		objects...,
+	)
+}
+
+func newTestInjectorWithPolicies(t *testing.T, configMaps []runtime.Object, policies ...runtime.Object) (*Injector, *dynamicfake.FakeDynamicClient) {
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+
+	dynamicClient := newTestDynamicClient(policies...)
+	m := NewInjector(fake.NewSimpleClientset(configMaps...), "ns-node-affinity", "test-cm", WithPolicies(dynamicClient))
+	assert.NoError(t, m.Start(stopCh))
+
+	return m, dynamicClient
+}
+
+func TestConfigForNamespaceFromPolicy(t *testing.T) {
+	t.Parallel()
+
+	spec := NamespaceConfig{
+		NodeSelectorTerms: nodeSelectorTerms(),
+		Tolerations:       tolerations(),
+	}
+	m, _ := newTestInjectorWithPolicies(t, nil, policy(t, "testing-ns", spec))
+
+	config, _, err := m.configForNamespace("testing-ns")
+	assert.NoError(t, err)
+	assert.Equal(t, &spec, config)
+
+	cached, _, err := m.configForNamespace("testing-ns")
+	assert.NoError(t, err)
+	assert.Same(t, config, cached)
+}
+
+func TestConfigForNamespacePolicyTakesPrecedence(t *testing.T) {
+	t.Parallel()
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-cm",
+			Namespace: "ns-node-affinity",
+		},
+		Data: map[string]string{
+			"testing-ns": "tolerations: [{key: from-cm, operator: Exists}]",
+			"other-ns":   "tolerations: [{key: from-cm, operator: Exists}]",
+		},
+	}
+	spec := NamespaceConfig{
+		Tolerations: []corev1.Toleration{{Key: "from-policy", Operator: corev1.TolerationOpExists}},
+	}
+	m, _ := newTestInjectorWithPolicies(t, []runtime.Object{cm}, policy(t, "testing-ns", spec))
+
+	config, _, err := m.configForNamespace("testing-ns")
+	assert.NoError(t, err)
+	assert.Equal(t, "from-policy", config.Tolerations[0].Key)
+
+	config, _, err = m.configForNamespace("other-ns")
+	assert.NoError(t, err)
+	assert.Equal(t, "from-cm", config.Tolerations[0].Key)
+}
+
+func TestConfigForNamespaceWithInvalidPolicy(t *testing.T) {
+	t.Parallel()
+
+	m, _ := newTestInjectorWithPolicies(t, nil, policy(t, "testing-ns", NamespaceConfig{}))
+
+	_, _, err := m.configForNamespace("testing-ns")
+	assert.True(t, errors.Is(err, ErrInvalidConfiguration))
+}
+
+func TestPolicyStatusIsUpdated(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name           string
+		spec           NamespaceConfig
+		expectedStatus metav1.ConditionStatus
+		expectedReason string
+	}{
+		{
+			name:           "Valid",
+			spec:           NamespaceConfig{Tolerations: tolerations()},
+			expectedStatus: metav1.ConditionTrue,
+			expectedReason: v1alpha1.ReasonValid,
+		},
+		{
+			name:           "Invalid",
+			spec:           NamespaceConfig{},
+			expectedStatus: metav1.ConditionFalse,
+			expectedReason: v1alpha1.ReasonInvalidConfiguration,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, dynamicClient := newTestInjectorWithPolicies(t, nil, policy(t, "testing-ns", tc.spec))
+
+			assert.Eventually(t, func() bool {
+				u, err := dynamicClient.Resource(v1alpha1.NamespaceAffinityPolicyResource).
+					Get(context.Background(), "testing-ns", metav1.GetOptions{})
+				if err != nil {
+					return false
+				}
+
+				raw, err := json.Marshal(u.Object["status"])
+				if err != nil {
+					return false
+				}
+				status := v1alpha1.NamespaceAffinityPolicyStatus{}
+				if err := json.Unmarshal(raw, &status); err != nil {
+					return false
+				}
+
+				condition := meta.FindStatusCondition(status.Conditions, v1alpha1.ConditionValid)
+				return status.ObservedGeneration == 1 && condition != nil &&
+					condition.Status == tc.expectedStatus && condition.Reason == tc.expectedReason
+			}, 5*time.Second, 10*time.Millisecond)
+		})
+	}
+}
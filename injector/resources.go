@@ -0,0 +1,189 @@
+package injector
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// resourcesConfigKey is the reserved ConfigMap key holding the custom
+// resources with pod templates or pod specs patched like pods
+const resourcesConfigKey = "_resources"
+
+// customResource is an entry of the "_resources" ConfigMap entry with the
+// JSON pointers to the pod templates and the pod specs in the objects of a
+// resource. A "*" segment matches all the elements of an array (eg:
+// "/spec/workerGroupSpecs/*/template"). An empty version matches all the
+// versions of the resource
+type customResource struct {
+	Group        string   `json:"group"`
+	Version      string   `json:"version,omitempty"`
+	Resource     string   `json:"resource"`
+	PodTemplates []string `json:"podTemplates,omitempty"`
+	PodSpecs     []string `json:"podSpecs,omitempty"`
+}
+
+// pointerValue is a value in an object and its JSON pointer
+type pointerValue struct {
+	pointer string
+	value   interface{}
+}
+
+// customResource returns the "_resources" entry for the group, version and
+// resource or nil if there is no such entry
+func (m *Injector) customResource(group, version, resource string) (*customResource, error) {
+	configMap, err := m.configMapLister.Get(m.configMapName)
+	if err != nil {
+		return nil, nil
+	}
+
+	resources, err := m.parseResources(configMap)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, r := range resources {
+		if r.Group == group && r.Resource == resource && (r.Version == "" || r.Version == version) {
+			return &resources[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// parseResources returns the parsed "_resources" entry of configMap
+func (m *Injector) parseResources(configMap *corev1.ConfigMap) ([]customResource, error) {
+	resourcesString, exists := configMap.Data[resourcesConfigKey]
+	if !exists {
+		return nil, nil
+	}
+
+	if resources, ok := m.resourcesCache.get(resourcesConfigKey, configMap.ResourceVersion); ok {
+		return resources, nil
+	}
+
+	var resources []customResource
+	if err := yamlUnmarshal([]byte(resourcesString), &resources); err != nil {
+		return nil, fmt.Errorf("%w: %s: %s", ErrInvalidConfiguration, resourcesConfigKey, err)
+	}
+
+	for _, r := range resources {
+		if r.Resource == "" {
+			return nil, fmt.Errorf("%w: %s: missing resource", ErrInvalidConfiguration, resourcesConfigKey)
+		}
+
+		if len(r.PodTemplates) == 0 && len(r.PodSpecs) == 0 {
+			return nil, fmt.Errorf("%w: %s: at least one of podTemplates or podSpecs needs to be specified for %s", ErrInvalidConfiguration, resourcesConfigKey, r.Resource)
+		}
+
+		for _, pointer := range concat(r.PodTemplates, r.PodSpecs) {
+			if !strings.HasPrefix(pointer, "/") {
+				return nil, fmt.Errorf("%w: %s: invalid JSON pointer %q for %s", ErrInvalidConfiguration, resourcesConfigKey, pointer, r.Resource)
+			}
+		}
+	}
+
+	m.resourcesCache.set(resourcesConfigKey, configMap.ResourceVersion, resources)
+
+	return resources, nil
+}
+
+// podTargets returns the targets for the pod templates and the pod specs in
+// the object (raw) of the resource. The pods of the pod specs have the
+// metadata of the object, which is where their config hash is recorded.
+// Missing pod templates and pod specs are skipped
+func (r *customResource) podTargets(raw []byte) ([]podTarget, error) {
+	var obj interface{}
+	if err := jsonUnmarshal(raw, &obj); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAdmissionReviewObj, err)
+	}
+
+	var targets []podTarget
+
+	for _, pointer := range r.PodTemplates {
+		for _, v := range resolvePointer(obj, pointer) {
+			template := corev1.PodTemplateSpec{}
+			if err := convertValue(v.value, &template); err != nil {
+				return nil, fmt.Errorf("%w: invalid pod template %s of %s: %v", ErrInvalidAdmissionReviewObj, v.pointer, r.Resource, err)
+			}
+
+			target := podTargetForTemplate(template, v.pointer)
+			target.missingMetadata = len(resolvePointer(v.value, "/metadata")) == 0
+			targets = append(targets, target)
+		}
+	}
+
+	if len(r.PodSpecs) == 0 {
+		return targets, nil
+	}
+
+	metadata := metav1.ObjectMeta{}
+	for _, v := range resolvePointer(obj, "/metadata") {
+		if err := convertValue(v.value, &metadata); err != nil {
+			return nil, fmt.Errorf("%w: invalid metadata of %s: %v", ErrInvalidAdmissionReviewObj, r.Resource, err)
+		}
+	}
+
+	for _, pointer := range r.PodSpecs {
+		for _, v := range resolvePointer(obj, pointer) {
+			spec := corev1.PodSpec{}
+			if err := convertValue(v.value, &spec); err != nil {
+				return nil, fmt.Errorf("%w: invalid pod spec %s of %s: %v", ErrInvalidAdmissionReviewObj, v.pointer, r.Resource, err)
+			}
+
+			targets = append(targets, podTarget{
+				pod:          &corev1.Pod{ObjectMeta: *metadata.DeepCopy(), Spec: spec},
+				specRoot:     v.pointer,
+				metadataRoot: "/metadata",
+			})
+		}
+	}
+
+	return targets, nil
+}
+
+// resolvePointer returns the values at the JSON pointer in obj. A "*"
+// segment matches all the elements of an array and the returned pointers
+// have the "*" segments replaced by the indexes of the elements. Missing and
+// null values are skipped
+func resolvePointer(obj interface{}, pointer string) []pointerValue {
+	values := []pointerValue{{value: obj}}
+
+	for _, segment := range strings.Split(pointer, "/")[1:] {
+		var next []pointerValue
+
+		for _, v := range values {
+			switch value := v.value.(type) {
+			case map[string]interface{}:
+				key := strings.NewReplacer("~1", "/", "~0", "~").Replace(segment)
+				if child, ok := value[key]; ok && child != nil {
+					next = append(next, pointerValue{pointer: v.pointer + "/" + segment, value: child})
+				}
+			case []interface{}:
+				for i, child := range value {
+					if child != nil && (segment == "*" || segment == strconv.Itoa(i)) {
+						next = append(next, pointerValue{pointer: v.pointer + "/" + strconv.Itoa(i), value: child})
+					}
+				}
+			}
+		}
+
+		values = next
+	}
+
+	return values
+}
+
+// convertValue converts a value of an unstructured object into out
+func convertValue(value interface{}, out interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return jsonUnmarshal(data, out)
+}
@@ -0,0 +1,279 @@
+package injector
+
+import (
+	"encoding/json"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const testResources = `
+- group: ray.io
+  version: v1
+  resource: rayclusters
+  podTemplates:
+    - /spec/headGroupSpec/template
+    - /spec/workerGroupSpecs/*/template
+- group: sparkoperator.k8s.io
+  resource: sparkapplications
+  podSpecs:
+    - /spec/driver
+    - /spec/executor
+`
+
+func customResourceRequest(resource metav1.GroupVersionResource, obj string) *admissionv1.AdmissionRequest {
+	return &admissionv1.AdmissionRequest{
+		Kind:      metav1.GroupVersionKind{Group: resource.Group, Version: resource.Version, Kind: "Custom"},
+		Resource:  resource,
+		Name:      "custom",
+		Namespace: "testing-ns",
+		Object:    runtime.RawExtension{Raw: []byte(obj)},
+	}
+}
+
+func TestCustomResource(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		resources     string
+		resource      metav1.GroupVersionResource
+		expectedFound bool
+		expectedErr   error
+	}{
+		{
+			name:          "MatchingVersion",
+			resources:     testResources,
+			resource:      metav1.GroupVersionResource{Group: "ray.io", Version: "v1", Resource: "rayclusters"},
+			expectedFound: true,
+		},
+		{
+			name:      "OtherVersion",
+			resources: testResources,
+			resource:  metav1.GroupVersionResource{Group: "ray.io", Version: "v1alpha1", Resource: "rayclusters"},
+		},
+		{
+			name:          "AnyVersion",
+			resources:     testResources,
+			resource:      metav1.GroupVersionResource{Group: "sparkoperator.k8s.io", Version: "v1beta2", Resource: "sparkapplications"},
+			expectedFound: true,
+		},
+		{
+			name:      "Pods",
+			resources: testResources,
+			resource:  metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+		},
+		{
+			name:        "InvalidYAML",
+			resources:   "{invalid",
+			resource:    metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+			expectedErr: ErrInvalidConfiguration,
+		},
+		{
+			name:        "MissingResource",
+			resources:   "[{group: ray.io, podTemplates: [/spec/template]}]",
+			resource:    metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+			expectedErr: ErrInvalidConfiguration,
+		},
+		{
+			name:        "MissingPaths",
+			resources:   "[{group: ray.io, resource: rayclusters}]",
+			resource:    metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+			expectedErr: ErrInvalidConfiguration,
+		},
+		{
+			name:        "InvalidPointer",
+			resources:   "[{group: ray.io, resource: rayclusters, podSpecs: [spec]}]",
+			resource:    metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+			expectedErr: ErrInvalidConfiguration,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := newTestInjectorWithConfig(t, map[string]string{resourcesConfigKey: tc.resources})
+
+			resource, err := m.customResource(tc.resource.Group, tc.resource.Version, tc.resource.Resource)
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedFound, resource != nil)
+		})
+	}
+}
+
+func TestResolvePointer(t *testing.T) {
+	t.Parallel()
+
+	var obj interface{}
+	assert.NoError(t, json.Unmarshal([]byte(`{
+		"spec": {
+			"a/b": {"c": 1},
+			"groups": [{"template": {"x": 1}}, {"other": 2}, {"template": {"x": 3}}],
+			"empty": null
+		}
+	}`), &obj))
+
+	testCases := []struct {
+		name             string
+		pointer          string
+		expectedPointers []string
+	}{
+		{
+			name:             "Field",
+			pointer:          "/spec/groups",
+			expectedPointers: []string{"/spec/groups"},
+		},
+		{
+			name:             "EscapedField",
+			pointer:          "/spec/a~1b/c",
+			expectedPointers: []string{"/spec/a~1b/c"},
+		},
+		{
+			name:             "Index",
+			pointer:          "/spec/groups/2/template",
+			expectedPointers: []string{"/spec/groups/2/template"},
+		},
+		{
+			name:             "Wildcard",
+			pointer:          "/spec/groups/*/template",
+			expectedPointers: []string{"/spec/groups/0/template", "/spec/groups/2/template"},
+		},
+		{
+			name:    "Missing",
+			pointer: "/spec/missing/template",
+		},
+		{
+			name:    "Null",
+			pointer: "/spec/empty",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var pointers []string
+			for _, v := range resolvePointer(obj, tc.pointer) {
+				pointers = append(pointers, v.pointer)
+			}
+
+			assert.Equal(t, tc.expectedPointers, pointers)
+		})
+	}
+}
+
+func TestMutateCustomResources(t *testing.T) {
+	t.Parallel()
+
+	rayClusters := metav1.GroupVersionResource{Group: "ray.io", Version: "v1", Resource: "rayclusters"}
+	sparkApplications := metav1.GroupVersionResource{Group: "sparkoperator.k8s.io", Version: "v1beta2", Resource: "sparkapplications"}
+	nodeSelector := map[string]interface{}{"pool": "a"}
+
+	testCases := []struct {
+		name          string
+		req           *admissionv1.AdmissionRequest
+		expectedPatch []JSONPatch
+	}{
+		{
+			name: "PodTemplates",
+			req: customResourceRequest(rayClusters, `{
+				"metadata": {"name": "custom"},
+				"spec": {
+					"headGroupSpec": {"template": {"spec": {}}},
+					"workerGroupSpecs": [
+						{"template": {"metadata": {"annotations": {"a": "b"}}, "spec": {}}},
+						{"template": {"metadata": {"labels": {"app": "excluded"}}, "spec": {}}}
+					]
+				}
+			}`),
+			expectedPatch: []JSONPatch{
+				{Op: "add", Path: "/spec/headGroupSpec/template/spec/nodeSelector", Value: nodeSelector},
+				{Op: "add", Path: "/spec/headGroupSpec/template/metadata"},
+				{Op: "add", Path: "/spec/workerGroupSpecs/0/template/spec/nodeSelector", Value: nodeSelector},
+				{Op: "add", Path: "/spec/workerGroupSpecs/0/template/metadata/annotations/namespace-node-affinity.idgenchev.github.com~1applied-config"},
+				{Op: "add", Path: "/spec/workerGroupSpecs/0/template/metadata/annotations/namespace-node-affinity.idgenchev.github.com~1config-hash"},
+			},
+		},
+		{
+			name: "PodSpecs",
+			req: customResourceRequest(sparkApplications, `{
+				"metadata": {"name": "custom"},
+				"spec": {
+					"driver": {"cores": 1},
+					"executor": {"cores": 2, "nodeSelector": {"disk": "ssd"}}
+				}
+			}`),
+			expectedPatch: []JSONPatch{
+				{Op: "add", Path: "/spec/driver/nodeSelector", Value: nodeSelector},
+				{Op: "add", Path: "/metadata/annotations"},
+				{Op: "add", Path: "/spec/executor/nodeSelector/pool", Value: "a"},
+			},
+		},
+		{
+			name: "MissingPodSpecs",
+			req:  customResourceRequest(sparkApplications, `{"metadata": {"name": "custom"}, "spec": {}}`),
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := newTestInjectorWithConfig(t, map[string]string{
+				resourcesConfigKey: testResources,
+				"testing-ns":       "{nodeSelector: {pool: a}, excludedLabels: {app: excluded}}",
+			})
+
+			admissionReview := admissionv1.AdmissionReview{
+				TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+				Request:  tc.req,
+			}
+			j, err := json.Marshal(admissionReview)
+			assert.NoError(t, err)
+
+			body, err := m.Mutate(j)
+			assert.NoError(t, err)
+
+			resp := admissionv1.AdmissionReview{}
+			assert.NoError(t, json.Unmarshal(body, &resp))
+			assert.True(t, resp.Response.Allowed)
+
+			if tc.expectedPatch == nil {
+				assert.Nil(t, resp.Response.Patch)
+				return
+			}
+
+			patch, err := jsonpatch.DecodePatch(resp.Response.Patch)
+			assert.NoError(t, err)
+			_, err = patch.Apply(tc.req.Object.Raw)
+			assert.NoError(t, err, "the patch cannot be applied to the object")
+
+			var patches []JSONPatch
+			assert.NoError(t, json.Unmarshal(resp.Response.Patch, &patches))
+			if !assert.Len(t, patches, len(tc.expectedPatch)) {
+				return
+			}
+
+			// The values of the annotations patches are not compared
+			for i := range patches {
+				if tc.expectedPatch[i].Value == nil {
+					patches[i].Value = nil
+				}
+			}
+			assert.Equal(t, tc.expectedPatch, patches)
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package injector
+
+import (
+	"fmt"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	v1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const admissionReviewKind = "AdmissionReview"
+
+// admissionReview is a decoded admission.k8s.io/v1 or v1beta1 AdmissionReview.
+// The request is converted to admission.k8s.io/v1 and the response is
+// encoded with the apiVersion of the request
+type admissionReview struct {
+	apiVersion string
+	request    *admissionv1.AdmissionRequest
+}
+
+// decodeAdmissionReview unmarshalls body into the AdmissionReview type
+// matching its apiVersion. AdmissionReviews without apiVersion are decoded as
+// admission.k8s.io/v1beta1
+func decodeAdmissionReview(body []byte) (*admissionReview, error) {
+	typeMeta := metav1.TypeMeta{}
+	if err := jsonUnmarshal(body, &typeMeta); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidAdmissionReview, err)
+	}
+
+	switch typeMeta.APIVersion {
+	case admissionv1.SchemeGroupVersion.String():
+		review := admissionv1.AdmissionReview{}
+		if err := jsonUnmarshal(body, &review); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidAdmissionReview, err)
+		}
+
+		return &admissionReview{apiVersion: typeMeta.APIVersion, request: review.Request}, nil
+	case "", v1beta1.SchemeGroupVersion.String():
+		review := v1beta1.AdmissionReview{}
+		if err := jsonUnmarshal(body, &review); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidAdmissionReview, err)
+		}
+
+		return &admissionReview{apiVersion: v1beta1.SchemeGroupVersion.String(), request: v1RequestFromV1beta1(review.Request)}, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported apiVersion %q", ErrInvalidAdmissionReview, typeMeta.APIVersion)
+	}
+}
+
+// encode marshals the AdmissionReview with the request and resp using the
+// apiVersion of the request
+func (r *admissionReview) encode(resp *admissionv1.AdmissionResponse) ([]byte, error) {
+	typeMeta := metav1.TypeMeta{APIVersion: r.apiVersion, Kind: admissionReviewKind}
+
+	if r.apiVersion == admissionv1.SchemeGroupVersion.String() {
+		return jsonMarshal(admissionv1.AdmissionReview{
+			TypeMeta: typeMeta,
+			Request:  r.request,
+			Response: resp,
+		})
+	}
+
+	return jsonMarshal(v1beta1.AdmissionReview{
+		TypeMeta: typeMeta,
+		Request:  v1beta1Request(r.request),
+		Response: v1beta1Response(resp),
+	})
+}
+
+// respond records resp in the metrics of webhook and encodes it
+func (r *admissionReview) respond(webhook string, resp *admissionv1.AdmissionResponse) ([]byte, error) {
+	observeResponse(webhook, r.request, resp)
+
+	return r.encode(resp)
+}
+
+// isDryRun reports whether req is a dry run. Dry runs must not have side
+// effects other than the metrics
+func isDryRun(req *admissionv1.AdmissionRequest) bool {
+	return req.DryRun != nil && *req.DryRun
+}
+
+func v1RequestFromV1beta1(req *v1beta1.AdmissionRequest) *admissionv1.AdmissionRequest {
+	if req == nil {
+		return nil
+	}
+
+	return &admissionv1.AdmissionRequest{
+		UID:                req.UID,
+		Kind:               req.Kind,
+		Resource:           req.Resource,
+		SubResource:        req.SubResource,
+		RequestKind:        req.RequestKind,
+		RequestResource:    req.RequestResource,
+		RequestSubResource: req.RequestSubResource,
+		Name:               req.Name,
+		Namespace:          req.Namespace,
+		Operation:          admissionv1.Operation(req.Operation),
+		UserInfo:           req.UserInfo,
+		Object:             req.Object,
+		OldObject:          req.OldObject,
+		DryRun:             req.DryRun,
+		Options:            req.Options,
+	}
+}
+
+func v1beta1Request(req *admissionv1.AdmissionRequest) *v1beta1.AdmissionRequest {
+	return &v1beta1.AdmissionRequest{
+		UID:                req.UID,
+		Kind:               req.Kind,
+		Resource:           req.Resource,
+		SubResource:        req.SubResource,
+		RequestKind:        req.RequestKind,
+		RequestResource:    req.RequestResource,
+		RequestSubResource: req.RequestSubResource,
+		Name:               req.Name,
+		Namespace:          req.Namespace,
+		Operation:          v1beta1.Operation(req.Operation),
+		UserInfo:           req.UserInfo,
+		Object:             req.Object,
+		OldObject:          req.OldObject,
+		DryRun:             req.DryRun,
+		Options:            req.Options,
+	}
+}
+
+func v1beta1Response(resp *admissionv1.AdmissionResponse) *v1beta1.AdmissionResponse {
+	var patchType *v1beta1.PatchType
+	if resp.PatchType != nil {
+		pt := v1beta1.PatchType(*resp.PatchType)
+		patchType = &pt
+	}
+
+	return &v1beta1.AdmissionResponse{
+		UID:              resp.UID,
+		Allowed:          resp.Allowed,
+		Result:           resp.Result,
+		Patch:            resp.Patch,
+		PatchType:        patchType,
+		AuditAnnotations: resp.AuditAnnotations,
+		Warnings:         resp.Warnings,
+	}
+}
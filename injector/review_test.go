@@ -0,0 +1,157 @@
+package injector
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	v1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestDecodeAdmissionReview(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name               string
+		body               string
+		expectedAPIVersion string
+		expectedErr        error
+	}{
+		{
+			name:               "V1",
+			body:               `{"apiVersion": "admission.k8s.io/v1", "kind": "AdmissionReview", "request": {"uid": "1"}}`,
+			expectedAPIVersion: "admission.k8s.io/v1",
+		},
+		{
+			name:               "V1beta1",
+			body:               `{"apiVersion": "admission.k8s.io/v1beta1", "kind": "AdmissionReview", "request": {"uid": "1"}}`,
+			expectedAPIVersion: "admission.k8s.io/v1beta1",
+		},
+		{
+			name:               "MissingAPIVersion",
+			body:               `{"request": {"uid": "1"}}`,
+			expectedAPIVersion: "admission.k8s.io/v1beta1",
+		},
+		{
+			name:        "UnsupportedAPIVersion",
+			body:        `{"apiVersion": "admission.k8s.io/v2", "kind": "AdmissionReview", "request": {"uid": "1"}}`,
+			expectedErr: ErrInvalidAdmissionReview,
+		},
+		{
+			name:        "InvalidRequest",
+			body:        `{"apiVersion": "admission.k8s.io/v1", "kind": "AdmissionReview", "request": "invalid"}`,
+			expectedErr: ErrInvalidAdmissionReview,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			review, err := decodeAdmissionReview([]byte(tc.body))
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedAPIVersion, review.apiVersion)
+			assert.Equal(t, "1", string(review.request.UID))
+		})
+	}
+}
+
+func TestMutateWithAdmissionReviewV1(t *testing.T) {
+	t.Parallel()
+
+	m := newTestInjectorWithConfig(t, map[string]string{
+		"testing-ns": "{nodeSelector: {pool: a}}",
+	})
+
+	admissionReview := admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       "uid",
+			Namespace: "testing-ns",
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Object: &corev1.Pod{}},
+		},
+	}
+	j, err := json.Marshal(admissionReview)
+	assert.NoError(t, err)
+
+	body, err := m.Mutate(j)
+	assert.NoError(t, err)
+
+	resp := admissionv1.AdmissionReview{}
+	assert.NoError(t, json.Unmarshal(body, &resp))
+	assert.Equal(t, admissionReview.TypeMeta, resp.TypeMeta)
+	assert.Equal(t, admissionReview.Request.UID, resp.Response.UID)
+	assert.True(t, resp.Response.Allowed)
+	assert.Equal(t, admissionv1.PatchTypeJSONPatch, *resp.Response.PatchType)
+	assert.JSONEq(t, `[{"op":"add","path":"/spec/nodeSelector","value":{"pool":"a"}}]`, withoutConfigHashPatch(t, resp.Response.Patch))
+}
+
+func TestMutateWithAdmissionReviewV1beta1(t *testing.T) {
+	t.Parallel()
+
+	m := newTestInjectorWithConfig(t, map[string]string{
+		"testing-ns": "{nodeSelector: {pool: a}}",
+	})
+
+	admissionReview := v1beta1.AdmissionReview{
+		TypeMeta: v1beta1TypeMeta,
+		Request: &v1beta1.AdmissionRequest{
+			UID:       "uid",
+			Namespace: "testing-ns",
+			Operation: v1beta1.Create,
+			Object:    runtime.RawExtension{Object: &corev1.Pod{}},
+		},
+	}
+	j, err := json.Marshal(admissionReview)
+	assert.NoError(t, err)
+
+	body, err := m.Mutate(j)
+	assert.NoError(t, err)
+
+	resp := v1beta1.AdmissionReview{}
+	assert.NoError(t, json.Unmarshal(body, &resp))
+	assert.Equal(t, v1beta1TypeMeta, resp.TypeMeta)
+	assert.Equal(t, admissionReview.Request.UID, resp.Response.UID)
+	assert.True(t, resp.Response.Allowed)
+	assert.Equal(t, v1beta1.PatchTypeJSONPatch, *resp.Response.PatchType)
+	assert.JSONEq(t, `[{"op":"add","path":"/spec/nodeSelector","value":{"pool":"a"}}]`, withoutConfigHashPatch(t, resp.Response.Patch))
+}
+
+func TestValidateWithAdmissionReviewV1(t *testing.T) {
+	t.Parallel()
+
+	m := newTestInjectorWithConfig(t, map[string]string{
+		"testing-ns": "{tolerations: [{key: example-key, operator: Exists, effect: NoSchedule}]}",
+	})
+
+	admissionReview := admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       "uid",
+			Namespace: "testing-ns",
+			Object:    runtime.RawExtension{Object: &corev1.Pod{}},
+		},
+	}
+	j, err := json.Marshal(admissionReview)
+	assert.NoError(t, err)
+
+	body, err := m.Validate(j)
+	assert.NoError(t, err)
+
+	resp := admissionv1.AdmissionReview{}
+	assert.NoError(t, json.Unmarshal(body, &resp))
+	assert.Equal(t, admissionReview.TypeMeta, resp.TypeMeta)
+	assert.Equal(t, admissionReview.Request.UID, resp.Response.UID)
+	assert.False(t, resp.Response.Allowed)
+}
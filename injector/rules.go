@@ -0,0 +1,91 @@
+package injector
+
+import (
+	"fmt"
+
+	"github.com/idgenchev/namespace-node-affinity/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// applyRules returns the config for a pod with podLabels. The terms and
+// tolerations of the rules matching the pod replace the ones of config. With
+// "all" rule matching, the terms and tolerations of all matching rules are
+// concatenated in order
+func applyRules(config *NamespaceConfig, podLabels map[string]string) (*NamespaceConfig, error) {
+	if len(config.Rules) == 0 {
+		return config, nil
+	}
+
+	rules, err := matchRules(config, podLabels)
+	if err != nil {
+		return nil, err
+	}
+
+	effective := *config
+	effective.Rules = nil
+
+	matched := v1alpha1.PodRule{}
+	for _, rule := range rules {
+		matched.NodeSelectorTerms = concat(matched.NodeSelectorTerms, rule.NodeSelectorTerms)
+		matched.PreferredNodeSelectorTerms = concat(matched.PreferredNodeSelectorTerms, rule.PreferredNodeSelectorTerms)
+		matched.Tolerations = concat(matched.Tolerations, rule.Tolerations)
+	}
+
+	if matched.NodeSelectorTerms != nil {
+		effective.NodeSelectorTerms = matched.NodeSelectorTerms
+	}
+	if matched.PreferredNodeSelectorTerms != nil {
+		effective.PreferredNodeSelectorTerms = matched.PreferredNodeSelectorTerms
+	}
+	if matched.Tolerations != nil {
+		effective.Tolerations = matched.Tolerations
+	}
+
+	return &effective, nil
+}
+
+// matchRules returns the rules of config matching a pod with podLabels. Only
+// the first matching rule is returned unless the rule matching is "all"
+func matchRules(config *NamespaceConfig, podLabels map[string]string) ([]v1alpha1.PodRule, error) {
+	var matched []v1alpha1.PodRule
+	for _, rule := range config.Rules {
+		selector, err := metav1.LabelSelectorAsSelector(rule.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid selector for rule %s: %s", ErrInvalidConfiguration, rule.Name, err)
+		}
+
+		if !selector.Matches(labels.Set(podLabels)) {
+			continue
+		}
+
+		matched = append(matched, rule)
+
+		if config.RuleMatching != v1alpha1.RuleMatchingAll {
+			break
+		}
+	}
+
+	return matched, nil
+}
+
+// validateRules checks the rule matching and the selectors of the rules
+func validateRules(config *NamespaceConfig) error {
+	switch config.RuleMatching {
+	case "", v1alpha1.RuleMatchingFirst, v1alpha1.RuleMatchingAll:
+	default:
+		return fmt.Errorf("%w: invalid ruleMatching %q", ErrInvalidConfiguration, config.RuleMatching)
+	}
+
+	for _, rule := range config.Rules {
+		if rule.Selector == nil {
+			return fmt.Errorf("%w: missing selector for rule %s", ErrInvalidConfiguration, rule.Name)
+		}
+
+		if _, err := metav1.LabelSelectorAsSelector(rule.Selector); err != nil {
+			return fmt.Errorf("%w: invalid selector for rule %s: %s", ErrInvalidConfiguration, rule.Name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package injector
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/idgenchev/namespace-node-affinity/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func rulesConfig(ruleMatching v1alpha1.RuleMatching) *NamespaceConfig {
+	return &NamespaceConfig{
+		NodeSelectorTerms: nodeSelectorTerms(),
+		Tolerations:       []corev1.Toleration{{Key: "namespace", Operator: corev1.TolerationOpExists}},
+		RuleMatching:      ruleMatching,
+		Rules: []v1alpha1.PodRule{
+			{
+				Name:        "batch",
+				Selector:    &metav1.LabelSelector{MatchLabels: map[string]string{"workload": "batch"}},
+				Tolerations: []corev1.Toleration{{Key: "batch", Operator: corev1.TolerationOpExists}},
+			},
+			{
+				Name: "spot",
+				Selector: &metav1.LabelSelector{
+					MatchExpressions: []metav1.LabelSelectorRequirement{
+						{Key: "spot", Operator: metav1.LabelSelectorOpExists},
+					},
+				},
+				Tolerations: []corev1.Toleration{{Key: "spot", Operator: corev1.TolerationOpExists}},
+			},
+		},
+	}
+}
+
+func TestApplyRules(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name         string
+		ruleMatching v1alpha1.RuleMatching
+		podLabels    map[string]string
+		expectedKeys []string
+	}{
+		{
+			name:         "NoMatchingRule",
+			podLabels:    map[string]string{"workload": "web"},
+			expectedKeys: []string{"namespace"},
+		},
+		{
+			name:         "FirstMatchingRule",
+			podLabels:    map[string]string{"workload": "batch", "spot": "true"},
+			expectedKeys: []string{"batch"},
+		},
+		{
+			name:         "ExplicitFirstMatchingRule",
+			ruleMatching: v1alpha1.RuleMatchingFirst,
+			podLabels:    map[string]string{"workload": "batch", "spot": "true"},
+			expectedKeys: []string{"batch"},
+		},
+		{
+			name:         "AllMatchingRules",
+			ruleMatching: v1alpha1.RuleMatchingAll,
+			podLabels:    map[string]string{"workload": "batch", "spot": "true"},
+			expectedKeys: []string{"batch", "spot"},
+		},
+		{
+			name:         "SecondRuleOnly",
+			ruleMatching: v1alpha1.RuleMatchingAll,
+			podLabels:    map[string]string{"spot": "true"},
+			expectedKeys: []string{"spot"},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			config, err := applyRules(rulesConfig(tc.ruleMatching), tc.podLabels)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedKeys, tolerationKeys(config))
+			assert.Equal(t, nodeSelectorTerms(), config.NodeSelectorTerms, "fields not set by the rules should be kept")
+			assert.Nil(t, config.Rules)
+		})
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name   string
+		config *NamespaceConfig
+		valid  bool
+	}{
+		{
+			name:   "Valid",
+			config: rulesConfig(v1alpha1.RuleMatchingAll),
+			valid:  true,
+		},
+		{
+			name:   "InvalidRuleMatching",
+			config: rulesConfig("some"),
+		},
+		{
+			name: "MissingSelector",
+			config: &NamespaceConfig{
+				Rules: []v1alpha1.PodRule{{Name: "no-selector"}},
+			},
+		},
+		{
+			name: "InvalidSelector",
+			config: &NamespaceConfig{
+				Rules: []v1alpha1.PodRule{
+					{
+						Name: "invalid",
+						Selector: &metav1.LabelSelector{
+							MatchExpressions: []metav1.LabelSelectorRequirement{
+								{Key: "key", Operator: "Invalid"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := validateRules(tc.config)
+			if tc.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.True(t, errors.Is(err, ErrInvalidConfiguration))
+			}
+		})
+	}
+}
+
+func TestBuildPatchWithRules(t *testing.T) {
+	t.Parallel()
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: map[string]string{"workload": "batch"},
+		},
+	}
+
+	patch, err := buildPatch(rulesConfig(v1alpha1.RuleMatchingFirst), pod)
+	assert.NoError(t, err)
+
+	var patches []JSONPatch
+	assert.NoError(t, json.Unmarshal(patch, &patches))
+
+	var tolerationPatches []JSONPatch
+	for _, p := range patches {
+		if p.Path == AddTolerations {
+			tolerationPatches = append(tolerationPatches, p)
+		}
+	}
+
+	assert.Len(t, tolerationPatches, 1)
+	assert.Equal(t, "batch", tolerationPatches[0].Value.(map[string]interface{})["key"])
+}
@@ -0,0 +1,57 @@
+package injector
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// buildSchedulingPatches returns the patches setting the priorityClassName,
+// runtimeClassName and schedulerName of config on podSpec. Unless override is
+// set, only the fields podSpec does not set are patched. The schedulerName is
+// defaulted by the API server, so the default scheduler counts as not set
+func buildSchedulingPatches(podSpec corev1.PodSpec, config *NamespaceConfig) []JSONPatch {
+	var patches []JSONPatch
+	override := config.Override != nil && *config.Override
+
+	if config.PriorityClassName != "" && podSpec.PriorityClassName != config.PriorityClassName &&
+		(podSpec.PriorityClassName == "" || override) {
+		patches = append(patches, JSONPatch{
+			Op:    "add",
+			Path:  SetPriorityClassName,
+			Value: config.PriorityClassName,
+		})
+
+		// The priority resolved from the previous priorityClassName is
+		// rejected by the Priority admission plugin when it is reinvoked
+		if podSpec.Priority != nil {
+			patches = append(patches, JSONPatch{
+				Op:   "remove",
+				Path: RemovePriority,
+			})
+		}
+	}
+
+	if config.RuntimeClassName != "" && (podSpec.RuntimeClassName == nil || override) &&
+		(podSpec.RuntimeClassName == nil || *podSpec.RuntimeClassName != config.RuntimeClassName) {
+		patches = append(patches, JSONPatch{
+			Op:    "add",
+			Path:  SetRuntimeClassName,
+			Value: config.RuntimeClassName,
+		})
+	}
+
+	podSchedulerName := podSpec.SchedulerName
+	if podSchedulerName == corev1.DefaultSchedulerName {
+		podSchedulerName = ""
+	}
+
+	if config.SchedulerName != "" && podSpec.SchedulerName != config.SchedulerName &&
+		(podSchedulerName == "" || override) {
+		patches = append(patches, JSONPatch{
+			Op:    "add",
+			Path:  SetSchedulerName,
+			Value: config.SchedulerName,
+		})
+	}
+
+	return patches
+}
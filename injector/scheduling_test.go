@@ -0,0 +1,101 @@
+package injector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+func TestBuildSchedulingPatches(t *testing.T) {
+	t.Parallel()
+
+	config := &NamespaceConfig{
+		PriorityClassName: "preemptible",
+		RuntimeClassName:  "gvisor",
+		SchedulerName:     "batch-scheduler",
+	}
+	overrideConfig := *config
+	overrideConfig.Override = boolPtr(true)
+
+	allPatches := []JSONPatch{
+		{Op: "add", Path: SetPriorityClassName, Value: "preemptible"},
+		{Op: "add", Path: SetRuntimeClassName, Value: "gvisor"},
+		{Op: "add", Path: SetSchedulerName, Value: "batch-scheduler"},
+	}
+
+	podSpecWithOwnFields := corev1.PodSpec{
+		PriorityClassName: "critical",
+		Priority:          int32Ptr(1000),
+		RuntimeClassName:  stringPtr("kata"),
+		SchedulerName:     "own-scheduler",
+	}
+
+	testCases := []struct {
+		name            string
+		config          *NamespaceConfig
+		podSpec         corev1.PodSpec
+		expectedPatches []JSONPatch
+	}{
+		{
+			name:            "PodWithoutFields",
+			config:          config,
+			podSpec:         corev1.PodSpec{},
+			expectedPatches: allPatches,
+		},
+		{
+			name:            "PodWithDefaultScheduler",
+			config:          config,
+			podSpec:         corev1.PodSpec{SchedulerName: corev1.DefaultSchedulerName},
+			expectedPatches: allPatches,
+		},
+		{
+			name:    "PodWithOwnFields",
+			config:  config,
+			podSpec: podSpecWithOwnFields,
+		},
+		{
+			name:    "PodWithOwnFieldsAndOverride",
+			config:  &overrideConfig,
+			podSpec: podSpecWithOwnFields,
+			expectedPatches: []JSONPatch{
+				{Op: "add", Path: SetPriorityClassName, Value: "preemptible"},
+				{Op: "remove", Path: RemovePriority},
+				{Op: "add", Path: SetRuntimeClassName, Value: "gvisor"},
+				{Op: "add", Path: SetSchedulerName, Value: "batch-scheduler"},
+			},
+		},
+		{
+			name:   "PodWithTheSameFieldsAndOverride",
+			config: &overrideConfig,
+			podSpec: corev1.PodSpec{
+				PriorityClassName: "preemptible",
+				Priority:          int32Ptr(10),
+				RuntimeClassName:  stringPtr("gvisor"),
+				SchedulerName:     "batch-scheduler",
+			},
+		},
+		{
+			name:    "NoFields",
+			config:  &NamespaceConfig{Override: boolPtr(true)},
+			podSpec: podSpecWithOwnFields,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.expectedPatches, buildSchedulingPatches(tc.podSpec, tc.config))
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package injector
+
+import (
+	"fmt"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// podTarget is a pod, or a pod template or a pod spec in the object of an
+// admission request, which is patched like a pod
+type podTarget struct {
+	pod *corev1.Pod
+	// specRoot and metadataRoot are the JSON pointers in the object the
+	// "/spec" and the "/metadata" of the patches for pod are rebased to
+	specRoot     string
+	metadataRoot string
+	// missingMetadata is set for the pod templates without metadata in the
+	// object, which is created by the patch adding the annotations
+	missingMetadata bool
+}
+
+// podTargetForPod returns the target for a pod
+func podTargetForPod(pod *corev1.Pod) podTarget {
+	return podTarget{pod: pod, specRoot: "/spec", metadataRoot: "/metadata"}
+}
+
+// podTargetForTemplate returns the target for the pod template at root
+func podTargetForTemplate(template corev1.PodTemplateSpec, root string) podTarget {
+	return podTarget{
+		pod:          &corev1.Pod{ObjectMeta: template.ObjectMeta, Spec: template.Spec},
+		specRoot:     root + "/spec",
+		metadataRoot: root + "/metadata",
+	}
+}
+
+// rebase makes the paths of patches for the pod of t relative to the object
+func (t podTarget) rebase(patches []JSONPatch) []JSONPatch {
+	rebased := make([]JSONPatch, 0, len(patches))
+	for _, patch := range patches {
+		path := string(patch.Path)
+		switch {
+		case strings.HasPrefix(path, "/spec"):
+			path = t.specRoot + strings.TrimPrefix(path, "/spec")
+		case path == CreateAnnotations && t.missingMetadata:
+			path = t.metadataRoot
+			patch.Value = map[string]interface{}{"annotations": patch.Value}
+		case strings.HasPrefix(path, "/metadata"):
+			path = t.metadataRoot + strings.TrimPrefix(path, "/metadata")
+		}
+
+		patch.Path = PatchPath(path)
+		rebased = append(rebased, patch)
+	}
+
+	return rebased
+}
+
+// podTargets returns the targets in the object of req. The objects of the
+// resources in the "_resources" ConfigMap entry are patched at the
+// configured paths and the workloads at their pod template (when the
+// Injector is created WithWorkloads). Objects of any other kind are decoded
+// as pods. No targets are returned for the objects which are not patched
+func (m *Injector) podTargets(req *admissionv1.AdmissionRequest) ([]podTarget, error) {
+	resource, err := m.customResource(req.Resource.Group, req.Resource.Version, req.Resource.Resource)
+	if err != nil {
+		return nil, err
+	}
+
+	if resource != nil {
+		return resource.podTargets(req.Object.Raw)
+	}
+
+	template, isWorkload := podTemplates[workloadKind(req)]
+	if isWorkload {
+		if !m.workloads {
+			return nil, nil
+		}
+
+		return template.podTargets(req)
+	}
+
+	var pod *corev1.Pod
+	if err := jsonUnmarshal(req.Object.Raw, &pod); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAdmissionReviewObj, err)
+	}
+
+	return []podTarget{podTargetForPod(pod)}, nil
+}
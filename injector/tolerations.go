@@ -0,0 +1,160 @@
+package injector
+
+import (
+	"fmt"
+
+	"github.com/idgenchev/namespace-node-affinity/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// buildTolerationsPatches returns the patches adding the tolerations of config
+// which are not covered by the tolerations podSpec already has or by the
+// tolerations of config before them. NoExecute tolerations covered by a
+// toleration of the pod with different tolerationSeconds update the
+// tolerationSeconds of the pod according to the tolerationSecondsPolicy of
+// config
+func buildTolerationsPatches(podSpec corev1.PodSpec, config *NamespaceConfig) []JSONPatch {
+	var patches []JSONPatch
+	var added []corev1.Toleration
+
+	// The tolerations are added to the end of the list, which is initialised
+	// first when the pod has no tolerations
+	if podSpec.Tolerations == nil {
+		patches = append(patches, JSONPatch{
+			Op:    "add",
+			Path:  CreateTolerations,
+			Value: []corev1.Toleration{},
+		})
+	}
+
+	updated := map[int]bool{}
+
+	for _, toleration := range config.Tolerations {
+		if i, ok := coveringToleration(podSpec.Tolerations, toleration); ok {
+			if !updated[i] {
+				if patch, ok := buildTolerationSecondsPatch(i, podSpec.Tolerations[i], toleration, config.TolerationSecondsPolicy); ok {
+					patches = append(patches, patch)
+					updated[i] = true
+				}
+			}
+			continue
+		}
+
+		if _, ok := coveringToleration(added, toleration); ok {
+			continue
+		}
+
+		added = append(added, toleration)
+		patches = append(patches, JSONPatch{
+			Op:    "add",
+			Path:  AddTolerations,
+			Value: toleration,
+		})
+	}
+
+	if len(added) == 0 && podSpec.Tolerations == nil {
+		// Only the init patch, which is not needed
+		return nil
+	}
+
+	return patches
+}
+
+// coveringToleration returns the index of the first of tolerations covering
+// toleration
+func coveringToleration(tolerations []corev1.Toleration, toleration corev1.Toleration) (int, bool) {
+	for i, existing := range tolerations {
+		if toleratesAll(existing, toleration) {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+// toleratesAll reports whether existing tolerates every taint toleration
+// tolerates, ignoring tolerationSeconds
+func toleratesAll(existing, toleration corev1.Toleration) bool {
+	if existing.Effect != "" && existing.Effect != toleration.Effect {
+		return false
+	}
+
+	if existing.Operator == corev1.TolerationOpExists {
+		// An empty key with the Exists operator matches every key
+		return existing.Key == "" || existing.Key == toleration.Key
+	}
+
+	return toleration.Operator != corev1.TolerationOpExists &&
+		existing.Key == toleration.Key && existing.Value == toleration.Value
+}
+
+// buildTolerationSecondsPatch returns the patch updating the tolerationSeconds
+// of the toleration at index i of the pod with the tolerationSeconds of
+// toleration according to policy. The returned bool is false when the
+// tolerationSeconds of the pod are kept. Only NoExecute tolerations of the pod
+// for the same key are updated, as tolerationSeconds are invalid for the other
+// effects and a broader toleration is not narrowed down to toleration
+func buildTolerationSecondsPatch(i int, existing, toleration corev1.Toleration, policy v1alpha1.TolerationSecondsPolicy) (JSONPatch, bool) {
+	if toleration.Effect != corev1.TaintEffectNoExecute || existing.Effect != corev1.TaintEffectNoExecute ||
+		existing.Key == "" || existing.Key != toleration.Key ||
+		equalSeconds(existing.TolerationSeconds, toleration.TolerationSeconds) {
+		return JSONPatch{}, false
+	}
+
+	var seconds *int64
+	switch policy {
+	case v1alpha1.TolerationSecondsPolicyReplace:
+		seconds = toleration.TolerationSeconds
+	case v1alpha1.TolerationSecondsPolicyMax:
+		seconds = maxSeconds(existing.TolerationSeconds, toleration.TolerationSeconds)
+	case v1alpha1.TolerationSecondsPolicyMin:
+		seconds = minSeconds(existing.TolerationSeconds, toleration.TolerationSeconds)
+	default:
+		return JSONPatch{}, false
+	}
+
+	if equalSeconds(existing.TolerationSeconds, seconds) {
+		return JSONPatch{}, false
+	}
+
+	path := PatchPath(fmt.Sprintf("/spec/tolerations/%d/tolerationSeconds", i))
+	if seconds == nil {
+		return JSONPatch{Op: "remove", Path: path}, true
+	}
+
+	return JSONPatch{Op: "add", Path: path, Value: *seconds}, true
+}
+
+func equalSeconds(a, b *int64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return *a == *b
+}
+
+// maxSeconds returns the longer of a and b. nil is longer than any seconds
+func maxSeconds(a, b *int64) *int64 {
+	if a == nil || b == nil {
+		return nil
+	}
+
+	if *a > *b {
+		return a
+	}
+
+	return b
+}
+
+// minSeconds returns the shorter of a and b. nil is longer than any seconds
+func minSeconds(a, b *int64) *int64 {
+	if a == nil {
+		return b
+	}
+
+	if b == nil || *a < *b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,242 @@
+package injector
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/idgenchev/namespace-node-affinity/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func int64Ptr(i int64) *int64 {
+	return &i
+}
+
+func TestToleratesAll(t *testing.T) {
+	t.Parallel()
+
+	equal := corev1.Toleration{Key: "key", Operator: corev1.TolerationOpEqual, Value: "val", Effect: corev1.TaintEffectNoSchedule}
+
+	testCases := []struct {
+		name     string
+		existing corev1.Toleration
+		expected bool
+	}{
+		{
+			name:     "Identical",
+			existing: equal,
+			expected: true,
+		},
+		{
+			name:     "DefaultOperator",
+			existing: corev1.Toleration{Key: "key", Value: "val", Effect: corev1.TaintEffectNoSchedule},
+			expected: true,
+		},
+		{
+			name:     "DifferentValue",
+			existing: corev1.Toleration{Key: "key", Operator: corev1.TolerationOpEqual, Value: "other", Effect: corev1.TaintEffectNoSchedule},
+		},
+		{
+			name:     "DifferentEffect",
+			existing: corev1.Toleration{Key: "key", Operator: corev1.TolerationOpEqual, Value: "val", Effect: corev1.TaintEffectNoExecute},
+		},
+		{
+			name:     "AnyEffect",
+			existing: corev1.Toleration{Key: "key", Operator: corev1.TolerationOpEqual, Value: "val"},
+			expected: true,
+		},
+		{
+			name:     "ExistsForKey",
+			existing: corev1.Toleration{Key: "key", Operator: corev1.TolerationOpExists},
+			expected: true,
+		},
+		{
+			name:     "ExistsForOtherKey",
+			existing: corev1.Toleration{Key: "other", Operator: corev1.TolerationOpExists},
+		},
+		{
+			name:     "ExistsWildcard",
+			existing: corev1.Toleration{Operator: corev1.TolerationOpExists},
+			expected: true,
+		},
+		{
+			name:     "ExistsWildcardForOtherEffect",
+			existing: corev1.Toleration{Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.expected, toleratesAll(tc.existing, equal))
+		})
+	}
+
+	// Equal tolerations do not cover Exists tolerations for the same key
+	exists := corev1.Toleration{Key: "key", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule}
+	assert.False(t, toleratesAll(equal, exists))
+}
+
+func TestBuildTolerationsPatches(t *testing.T) {
+	t.Parallel()
+
+	noExecute := func(seconds *int64) corev1.Toleration {
+		return corev1.Toleration{
+			Key:               "node.kubernetes.io/unreachable",
+			Operator:          corev1.TolerationOpExists,
+			Effect:            corev1.TaintEffectNoExecute,
+			TolerationSeconds: seconds,
+		}
+	}
+
+	testCases := []struct {
+		name            string
+		podTolerations  []corev1.Toleration
+		config          *NamespaceConfig
+		expectedPatches []JSONPatch
+	}{
+		{
+			name:           "NewTolerations",
+			podTolerations: []corev1.Toleration{},
+			config:         &NamespaceConfig{Tolerations: tolerations()},
+			expectedPatches: []JSONPatch{
+				{Op: "add", Path: AddTolerations, Value: tolerations()[0]},
+				{Op: "add", Path: AddTolerations, Value: tolerations()[1]},
+			},
+		},
+		{
+			name:   "InitTolerations",
+			config: &NamespaceConfig{Tolerations: tolerations()},
+			expectedPatches: []JSONPatch{
+				{Op: "add", Path: CreateTolerations, Value: []corev1.Toleration{}},
+				{Op: "add", Path: AddTolerations, Value: tolerations()[0]},
+				{Op: "add", Path: AddTolerations, Value: tolerations()[1]},
+			},
+		},
+		{
+			name:   "NoInitWithoutNewTolerations",
+			config: &NamespaceConfig{},
+		},
+		{
+			name:           "ExistingTolerations",
+			podTolerations: tolerations()[:1],
+			config:         &NamespaceConfig{Tolerations: tolerations()},
+			expectedPatches: []JSONPatch{
+				{Op: "add", Path: AddTolerations, Value: tolerations()[1]},
+			},
+		},
+		{
+			name:           "ExistsWildcard",
+			podTolerations: []corev1.Toleration{{Operator: corev1.TolerationOpExists}},
+			config:         &NamespaceConfig{Tolerations: tolerations()},
+		},
+		{
+			name:           "DuplicateConfigTolerations",
+			podTolerations: []corev1.Toleration{},
+			config:         &NamespaceConfig{Tolerations: append(tolerations()[:1], tolerations()[0])},
+			expectedPatches: []JSONPatch{
+				{Op: "add", Path: AddTolerations, Value: tolerations()[0]},
+			},
+		},
+		{
+			name:           "KeepSecondsByDefault",
+			podTolerations: []corev1.Toleration{noExecute(int64Ptr(300))},
+			config:         &NamespaceConfig{Tolerations: []corev1.Toleration{noExecute(int64Ptr(60))}},
+		},
+		{
+			name:           "ReplaceSeconds",
+			podTolerations: []corev1.Toleration{noExecute(int64Ptr(300))},
+			config: &NamespaceConfig{
+				Tolerations:             []corev1.Toleration{noExecute(int64Ptr(60))},
+				TolerationSecondsPolicy: v1alpha1.TolerationSecondsPolicyReplace,
+			},
+			expectedPatches: []JSONPatch{
+				{Op: "add", Path: "/spec/tolerations/0/tolerationSeconds", Value: 60},
+			},
+		},
+		{
+			name:           "ReplaceWithUnlimitedSeconds",
+			podTolerations: []corev1.Toleration{noExecute(int64Ptr(300))},
+			config: &NamespaceConfig{
+				Tolerations:             []corev1.Toleration{noExecute(nil)},
+				TolerationSecondsPolicy: v1alpha1.TolerationSecondsPolicyReplace,
+			},
+			expectedPatches: []JSONPatch{
+				{Op: "remove", Path: "/spec/tolerations/0/tolerationSeconds"},
+			},
+		},
+		{
+			name:           "MaxSeconds",
+			podTolerations: []corev1.Toleration{noExecute(int64Ptr(300))},
+			config: &NamespaceConfig{
+				Tolerations:             []corev1.Toleration{noExecute(int64Ptr(600))},
+				TolerationSecondsPolicy: v1alpha1.TolerationSecondsPolicyMax,
+			},
+			expectedPatches: []JSONPatch{
+				{Op: "add", Path: "/spec/tolerations/0/tolerationSeconds", Value: 600},
+			},
+		},
+		{
+			name:           "MaxKeepsLongerPodSeconds",
+			podTolerations: []corev1.Toleration{noExecute(int64Ptr(300))},
+			config: &NamespaceConfig{
+				Tolerations:             []corev1.Toleration{noExecute(int64Ptr(60))},
+				TolerationSecondsPolicy: v1alpha1.TolerationSecondsPolicyMax,
+			},
+		},
+		{
+			name:           "KeepSecondsOfKeylessExists",
+			podTolerations: []corev1.Toleration{{Operator: corev1.TolerationOpExists}},
+			config: &NamespaceConfig{
+				Tolerations:             []corev1.Toleration{noExecute(int64Ptr(30))},
+				TolerationSecondsPolicy: v1alpha1.TolerationSecondsPolicyMin,
+			},
+		},
+		{
+			name:           "KeepSecondsOfKeylessNoExecute",
+			podTolerations: []corev1.Toleration{{Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute}},
+			config: &NamespaceConfig{
+				Tolerations:             []corev1.Toleration{noExecute(int64Ptr(30))},
+				TolerationSecondsPolicy: v1alpha1.TolerationSecondsPolicyReplace,
+			},
+		},
+		{
+			name:           "KeepSecondsWithoutEffect",
+			podTolerations: []corev1.Toleration{{Key: "node.kubernetes.io/unreachable", Operator: corev1.TolerationOpExists}},
+			config: &NamespaceConfig{
+				Tolerations:             []corev1.Toleration{noExecute(int64Ptr(30))},
+				TolerationSecondsPolicy: v1alpha1.TolerationSecondsPolicyReplace,
+			},
+		},
+		{
+			name:           "MinSeconds",
+			podTolerations: []corev1.Toleration{noExecute(nil)},
+			config: &NamespaceConfig{
+				Tolerations:             []corev1.Toleration{noExecute(int64Ptr(60))},
+				TolerationSecondsPolicy: v1alpha1.TolerationSecondsPolicyMin,
+			},
+			expectedPatches: []JSONPatch{
+				{Op: "add", Path: "/spec/tolerations/0/tolerationSeconds", Value: 60},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			podSpec := corev1.PodSpec{Tolerations: tc.podTolerations}
+			patches := buildTolerationsPatches(podSpec, tc.config)
+
+			expected, err := json.Marshal(tc.expectedPatches)
+			assert.NoError(t, err)
+			actual, err := json.Marshal(patches)
+			assert.NoError(t, err)
+			assert.JSONEq(t, string(expected), string(actual))
+		})
+	}
+}
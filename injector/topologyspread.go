@@ -0,0 +1,88 @@
+package injector
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// buildTopologySpreadConstraintsPatches returns the patches adding the
+// topologySpreadConstraints of config to pod. The constraints for a
+// topologyKey and whenUnsatisfiable pair the pod already has are skipped, as
+// the API server rejects pods with more than one constraint for the same pair
+func buildTopologySpreadConstraintsPatches(pod *corev1.Pod, config *NamespaceConfig) []JSONPatch {
+	var patches []JSONPatch
+
+	if pod.Spec.TopologySpreadConstraints == nil {
+		patches = append(patches, JSONPatch{
+			Op:    "add",
+			Path:  CreateTopologySpreadConstraints,
+			Value: []corev1.TopologySpreadConstraint{},
+		})
+	}
+
+	existing := append([]corev1.TopologySpreadConstraint{}, pod.Spec.TopologySpreadConstraints...)
+	for _, c := range config.TopologySpreadConstraints {
+		constraint := c.TopologySpreadConstraint
+		if hasTopologySpreadConstraint(existing, constraint) {
+			continue
+		}
+
+		if len(c.PodLabelKeys) > 0 {
+			constraint.LabelSelector = selectorWithPodLabels(constraint.LabelSelector, c.PodLabelKeys, pod.Labels)
+		}
+
+		existing = append(existing, constraint)
+		patches = append(patches, JSONPatch{
+			Op:    "add",
+			Path:  AddTopologySpreadConstraints,
+			Value: constraint,
+		})
+	}
+
+	if len(patches) == 1 && pod.Spec.TopologySpreadConstraints == nil {
+		// Only the init patch, which is not needed
+		return nil
+	}
+
+	return patches
+}
+
+func hasTopologySpreadConstraint(constraints []corev1.TopologySpreadConstraint, constraint corev1.TopologySpreadConstraint) bool {
+	for _, c := range constraints {
+		if c.TopologyKey == constraint.TopologyKey && c.WhenUnsatisfiable == constraint.WhenUnsatisfiable {
+			return true
+		}
+	}
+
+	return false
+}
+
+// selectorWithPodLabels returns a copy of selector with the labels of the pod
+// with keys added to its matchLabels. Keys the pod does not have are skipped
+// and selector is returned as is when the pod has none of the keys
+func selectorWithPodLabels(selector *metav1.LabelSelector, keys []string, podLabels map[string]string) *metav1.LabelSelector {
+	matchLabels := map[string]string{}
+	for _, key := range keys {
+		if value, ok := podLabels[key]; ok {
+			matchLabels[key] = value
+		}
+	}
+
+	if len(matchLabels) == 0 {
+		return selector
+	}
+
+	result := &metav1.LabelSelector{}
+	if selector != nil {
+		result = selector.DeepCopy()
+	}
+
+	if result.MatchLabels == nil {
+		result.MatchLabels = map[string]string{}
+	}
+	for k, v := range matchLabels {
+		result.MatchLabels[k] = v
+	}
+
+	return result
+}
@@ -0,0 +1,136 @@
+package injector
+
+import (
+	"testing"
+
+	"github.com/idgenchev/namespace-node-affinity/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func zoneSpreadConstraint(selector *metav1.LabelSelector) corev1.TopologySpreadConstraint {
+	return corev1.TopologySpreadConstraint{
+		MaxSkew:           1,
+		TopologyKey:       "topology.kubernetes.io/zone",
+		WhenUnsatisfiable: corev1.ScheduleAnyway,
+		LabelSelector:     selector,
+	}
+}
+
+func TestBuildTopologySpreadConstraintsPatches(t *testing.T) {
+	t.Parallel()
+
+	tierSelector := &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "web"}}
+
+	testCases := []struct {
+		name            string
+		pod             *corev1.Pod
+		constraint      v1alpha1.TopologySpreadConstraint
+		expectedPatches []JSONPatch
+	}{
+		{
+			name: "NoConstraints",
+			pod:  &corev1.Pod{},
+			constraint: v1alpha1.TopologySpreadConstraint{
+				TopologySpreadConstraint: zoneSpreadConstraint(tierSelector),
+			},
+			expectedPatches: []JSONPatch{
+				{Op: "add", Path: CreateTopologySpreadConstraints, Value: []corev1.TopologySpreadConstraint{}},
+				{Op: "add", Path: AddTopologySpreadConstraints, Value: zoneSpreadConstraint(tierSelector)},
+			},
+		},
+		{
+			name: "ExistingConstraints",
+			pod: &corev1.Pod{Spec: corev1.PodSpec{
+				TopologySpreadConstraints: []corev1.TopologySpreadConstraint{
+					{MaxSkew: 1, TopologyKey: "kubernetes.io/hostname", WhenUnsatisfiable: corev1.DoNotSchedule},
+				},
+			}},
+			constraint: v1alpha1.TopologySpreadConstraint{
+				TopologySpreadConstraint: zoneSpreadConstraint(tierSelector),
+			},
+			expectedPatches: []JSONPatch{
+				{Op: "add", Path: AddTopologySpreadConstraints, Value: zoneSpreadConstraint(tierSelector)},
+			},
+		},
+		{
+			name: "ExistingConstraintForTheSameTopologyKey",
+			pod: &corev1.Pod{Spec: corev1.PodSpec{
+				TopologySpreadConstraints: []corev1.TopologySpreadConstraint{zoneSpreadConstraint(nil)},
+			}},
+			constraint: v1alpha1.TopologySpreadConstraint{
+				TopologySpreadConstraint: zoneSpreadConstraint(tierSelector),
+			},
+		},
+		{
+			name: "SelectorFromPodLabels",
+			pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Labels: map[string]string{"app": "shop", "version": "v1"},
+			}},
+			constraint: v1alpha1.TopologySpreadConstraint{
+				TopologySpreadConstraint: zoneSpreadConstraint(tierSelector),
+				PodLabelKeys:             []string{"app", "missing"},
+			},
+			expectedPatches: []JSONPatch{
+				{Op: "add", Path: CreateTopologySpreadConstraints, Value: []corev1.TopologySpreadConstraint{}},
+				{
+					Op:   "add",
+					Path: AddTopologySpreadConstraints,
+					Value: zoneSpreadConstraint(&metav1.LabelSelector{
+						MatchLabels: map[string]string{"tier": "web", "app": "shop"},
+					}),
+				},
+			},
+		},
+		{
+			name: "SelectorFromPodLabelsWithoutSelector",
+			pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Labels: map[string]string{"app": "shop"},
+			}},
+			constraint: v1alpha1.TopologySpreadConstraint{
+				TopologySpreadConstraint: zoneSpreadConstraint(nil),
+				PodLabelKeys:             []string{"app"},
+			},
+			expectedPatches: []JSONPatch{
+				{Op: "add", Path: CreateTopologySpreadConstraints, Value: []corev1.TopologySpreadConstraint{}},
+				{
+					Op:    "add",
+					Path:  AddTopologySpreadConstraints,
+					Value: zoneSpreadConstraint(&metav1.LabelSelector{MatchLabels: map[string]string{"app": "shop"}}),
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			config := &NamespaceConfig{
+				TopologySpreadConstraints: []v1alpha1.TopologySpreadConstraint{tc.constraint},
+			}
+
+			patches := buildTopologySpreadConstraintsPatches(tc.pod, config)
+			assert.Equal(t, tc.expectedPatches, patches)
+			assert.Equal(t, map[string]string{"tier": "web"}, tierSelector.MatchLabels, "the config should not be modified")
+		})
+	}
+}
+
+func TestParseTopologySpreadConstraints(t *testing.T) {
+	t.Parallel()
+
+	config, err := parseNamespaceConfig(`
+topologySpreadConstraints:
+  - maxSkew: 1
+    topologyKey: topology.kubernetes.io/zone
+    whenUnsatisfiable: DoNotSchedule
+    podLabelKeys: [app]
+`)
+	assert.NoError(t, err)
+	assert.Len(t, config.TopologySpreadConstraints, 1)
+	assert.Equal(t, "topology.kubernetes.io/zone", config.TopologySpreadConstraints[0].TopologyKey)
+	assert.Equal(t, []string{"app"}, config.TopologySpreadConstraints[0].PodLabelKeys)
+}
@@ -0,0 +1,253 @@
+package injector
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/idgenchev/namespace-node-affinity/api/v1alpha1"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Validate unmarshalls the AdmissionReview (body) and checks that the pod in
+// the admission review request satisfies the nodeSelectorTerms, tolerations
+// and nodeSelector of the configuration for its namespace. Pods which do not
+// satisfy them are denied. Validate returns the marshalled AdmissionReview
+// or an error
+func (m *Injector) Validate(body []byte) ([]byte, error) {
+	log.Infof("Received AdmissionReview: %s\n", string(body))
+
+	admissionReview, err := decodeAdmissionReview(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var pod *corev1.Pod
+
+	req := admissionReview.request
+	if req == nil {
+		log.Warning("admissionReview with empty request")
+		return nil, nil
+	}
+
+	if err := jsonUnmarshal(req.Object.Raw, &pod); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAdmissionReviewObj, err)
+	}
+
+	resp := allowedResponse(req)
+
+	podNamespace := req.Namespace
+	if podNamespace == "" {
+		podNamespace = "default"
+	}
+
+	// Pods in namespaces without configuration have no policy to violate,
+	// so they are not blocked when the failure policy of the webhook is Fail
+	config, _, err := m.configForNamespace(podNamespace)
+	if errors.Is(err, ErrMissingConfiguration) {
+		log.Infof("Admitting pod in namespace %s without configuration: %s", podNamespace, err)
+		return admissionReview.respond(validateWebhook, resp)
+	} else if err != nil {
+		return nil, err
+	}
+
+	ignore, err := ignorePod(pod, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ignore {
+		config, err = applyRules(config, pod.Labels)
+		if err != nil {
+			return nil, err
+		}
+
+		if violations := placementViolations(config, pod.Spec); len(violations) > 0 {
+			log.Infof("Rejecting pod violating the placement policy of namespace %s: %s", podNamespace, strings.Join(violations, "; "))
+			resp.Allowed = false
+			resp.Result = violationStatus(podNamespace, violations)
+		}
+
+		if config.Mode == v1alpha1.ModeAudit {
+			auditResponse(resp, podNamespace)
+		}
+	}
+
+	responseBody, err := admissionReview.respond(validateWebhook, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("AdmissionReview response: %s\n", string(responseBody))
+
+	return responseBody, nil
+}
+
+// placementViolations returns the reasons podSpec does not satisfy the
+// nodeSelectorTerms, tolerations and nodeSelector of config. The node
+// affinity is not checked for configs which skip the pods with node affinity
+// of their own. Configs appending their nodeSelectorTerms to the ones of the
+// pod only require the pod to have them, as that is what the mutating
+// webhook does
+func placementViolations(config *NamespaceConfig, podSpec corev1.PodSpec) []string {
+	var violations []string
+
+	if config.NodeSelectorTerms != nil && config.ConflictStrategy != v1alpha1.ConflictStrategySkip {
+		if appendsNodeSelectorTerms(config) {
+			violations = append(violations, missingNodeSelectorTerms(config.NodeSelectorTerms, podSpec)...)
+		} else {
+			violations = append(violations, nodeSelectorTermsViolations(config.NodeSelectorTerms, podSpec)...)
+		}
+	}
+
+	violations = append(violations, missingTolerations(config, podSpec)...)
+
+	return append(violations, missingNodeSelector(config, podSpec)...)
+}
+
+// appendsNodeSelectorTerms reports whether the nodeSelectorTerms of config
+// are appended to the nodeSelectorTerms of the pods which have their own
+func appendsNodeSelectorTerms(config *NamespaceConfig) bool {
+	switch config.ConflictStrategy {
+	case "", v1alpha1.ConflictStrategyAppend:
+		return config.NodeSelectorTermsStrategy != v1alpha1.NodeSelectorTermsStrategyEnforce
+	}
+
+	return false
+}
+
+// missingNodeSelectorTerms returns the reasons podSpec does not have all of
+// nodeSelectorTerms. A term is present when one of the terms of podSpec
+// includes all of its requirements
+func missingNodeSelectorTerms(nodeSelectorTerms []corev1.NodeSelectorTerm, podSpec corev1.PodSpec) []string {
+	podTerms := podNodeSelectorTerms(podSpec)
+	if len(podTerms) == 0 {
+		if len(nodeSelectorTerms) == 0 {
+			return nil
+		}
+		return []string{"missing the required node affinity of the namespace"}
+	}
+
+	var missing []string
+	for i, term := range nodeSelectorTerms {
+		present := false
+		for _, podTerm := range podTerms {
+			if includesRequirements(podTerm, term) {
+				present = true
+				break
+			}
+		}
+
+		if !present {
+			missing = append(missing, fmt.Sprintf("missing nodeSelectorTerm %d of the namespace", i))
+		}
+	}
+
+	return missing
+}
+
+// missingTolerations returns the reasons podSpec does not tolerate all of the
+// tolerations of config
+func missingTolerations(config *NamespaceConfig, podSpec corev1.PodSpec) []string {
+	var missing []string
+	for _, toleration := range config.Tolerations {
+		if _, ok := coveringToleration(podSpec.Tolerations, toleration); !ok {
+			missing = append(missing, fmt.Sprintf("missing toleration for key %q with effect %q", toleration.Key, toleration.Effect))
+		}
+	}
+
+	return missing
+}
+
+// missingNodeSelector returns the reasons podSpec does not have all of the
+// nodeSelector labels of config. Conflicting values are allowed when they
+// are reported
+func missingNodeSelector(config *NamespaceConfig, podSpec corev1.PodSpec) []string {
+	conflicts := map[string]bool{}
+	if config.NodeSelectorConflictPolicy == v1alpha1.NodeSelectorConflictPolicyReport {
+		for _, key := range nodeSelectorConflicts(config, podSpec) {
+			conflicts[key] = true
+		}
+	}
+
+	var missing []string
+	for _, key := range sortedKeys(config.NodeSelector) {
+		if podVal, ok := podSpec.NodeSelector[key]; (!ok || podVal != config.NodeSelector[key]) && !conflicts[key] {
+			missing = append(missing, fmt.Sprintf("nodeSelector %s=%s is missing", key, config.NodeSelector[key]))
+		}
+	}
+
+	return missing
+}
+
+// nodeSelectorTermsViolations checks that every nodeSelectorTerm of podSpec
+// includes all of the requirements of at least one of nodeSelectorTerms, so
+// the pod can only be scheduled on nodes matching nodeSelectorTerms. Empty
+// terms match no nodes and are skipped
+func nodeSelectorTermsViolations(nodeSelectorTerms []corev1.NodeSelectorTerm, podSpec corev1.PodSpec) []string {
+	podTerms := podNodeSelectorTerms(podSpec)
+	if len(podTerms) == 0 {
+		return []string{"missing the required node affinity of the namespace"}
+	}
+
+	var violations []string
+	for i, podTerm := range podTerms {
+		if len(podTerm.MatchExpressions) == 0 && len(podTerm.MatchFields) == 0 {
+			continue
+		}
+
+		included := false
+		for _, term := range nodeSelectorTerms {
+			if includesRequirements(podTerm, term) {
+				included = true
+				break
+			}
+		}
+
+		if !included {
+			violations = append(violations, fmt.Sprintf("nodeSelectorTerm %d does not include the requirements of any of the nodeSelectorTerms of the namespace", i))
+		}
+	}
+
+	return violations
+}
+
+// includesRequirements reports whether podTerm has all of the requirements of
+// term
+func includesRequirements(podTerm, term corev1.NodeSelectorTerm) bool {
+	return includesAll(podTerm.MatchExpressions, term.MatchExpressions) &&
+		includesAll(podTerm.MatchFields, term.MatchFields)
+}
+
+func includesAll(requirements, expected []corev1.NodeSelectorRequirement) bool {
+	for _, e := range expected {
+		found := false
+		for _, r := range requirements {
+			if reflect.DeepEqual(r, e) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// violationStatus returns the status for denying the admission of a pod
+// violating the placement policy of namespace
+func violationStatus(namespace string, violations []string) *metav1.Status {
+	return &metav1.Status{
+		Status:  metav1.StatusFailure,
+		Message: fmt.Sprintf("the pod violates the placement policy of namespace %s: %s", namespace, strings.Join(violations, "; ")),
+		Reason:  metav1.StatusReasonForbidden,
+		Code:    http.StatusForbidden,
+	}
+}
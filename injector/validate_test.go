@@ -0,0 +1,323 @@
+package injector
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/idgenchev/namespace-node-affinity/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	v1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// podSpecWithNodeSelectorTerms returns a pod spec with terms as its required
+// nodeSelectorTerms
+func podSpecWithNodeSelectorTerms(terms ...corev1.NodeSelectorTerm) corev1.PodSpec {
+	return corev1.PodSpec{
+		Affinity: &corev1.Affinity{
+			NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: terms},
+			},
+		},
+	}
+}
+
+func TestPlacementViolations(t *testing.T) {
+	t.Parallel()
+
+	otherTerm := corev1.NodeSelectorTerm{
+		MatchExpressions: []corev1.NodeSelectorRequirement{
+			{
+				Key:      "other",
+				Operator: corev1.NodeSelectorOpExists,
+			},
+		},
+	}
+
+	testCases := []struct {
+		name               string
+		config             *NamespaceConfig
+		podSpec            corev1.PodSpec
+		expectedViolations []string
+	}{
+		{
+			name:    "MatchingNodeSelectorTerms",
+			config:  &NamespaceConfig{NodeSelectorTerms: nodeSelectorTerms()},
+			podSpec: podSpecWithExistingNodeSelectorTerms,
+		},
+		{
+			name:               "MissingNodeAffinity",
+			config:             &NamespaceConfig{NodeSelectorTerms: nodeSelectorTerms()},
+			podSpec:            podSpecWithNoAffinity,
+			expectedViolations: []string{"missing the required node affinity of the namespace"},
+		},
+		{
+			name: "NodeSelectorTermWithoutTheRequirements",
+			config: &NamespaceConfig{
+				NodeSelectorTerms:         []corev1.NodeSelectorTerm{otherTerm},
+				NodeSelectorTermsStrategy: v1alpha1.NodeSelectorTermsStrategyEnforce,
+			},
+			podSpec: podSpecWithExistingNodeSelectorTerms,
+			expectedViolations: []string{
+				"nodeSelectorTerm 0 does not include the requirements of any of the nodeSelectorTerms of the namespace",
+			},
+		},
+		{
+			name: "NodeSelectorTermWithoutTheRequirementsAndReplaceConflictStrategy",
+			config: &NamespaceConfig{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{otherTerm},
+				ConflictStrategy:  v1alpha1.ConflictStrategyReplace,
+			},
+			podSpec: podSpecWithExistingNodeSelectorTerms,
+			expectedViolations: []string{
+				"nodeSelectorTerm 0 does not include the requirements of any of the nodeSelectorTerms of the namespace",
+			},
+		},
+		{
+			name:    "AppendedNodeSelectorTerms",
+			config:  &NamespaceConfig{NodeSelectorTerms: []corev1.NodeSelectorTerm{otherTerm}},
+			podSpec: podSpecWithNodeSelectorTerms(nodeSelectorTerms()[0], otherTerm),
+		},
+		{
+			name:               "MissingAppendedNodeSelectorTerms",
+			config:             &NamespaceConfig{NodeSelectorTerms: []corev1.NodeSelectorTerm{otherTerm}},
+			podSpec:            podSpecWithExistingNodeSelectorTerms,
+			expectedViolations: []string{"missing nodeSelectorTerm 0 of the namespace"},
+		},
+		{
+			name: "SkipConflictStrategy",
+			config: &NamespaceConfig{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{otherTerm},
+				ConflictStrategy:  v1alpha1.ConflictStrategySkip,
+			},
+			podSpec: podSpecWithExistingNodeSelectorTerms,
+		},
+		{
+			name: "EmptyNodeSelectorTerms",
+			config: &NamespaceConfig{
+				NodeSelectorTerms:         nodeSelectorTerms(),
+				NodeSelectorTermsStrategy: v1alpha1.NodeSelectorTermsStrategyEnforce,
+			},
+			podSpec: podSpecWithEmptyNodeSelectorTerms,
+		},
+		{
+			name:    "Tolerations",
+			config:  &NamespaceConfig{Tolerations: tolerations()},
+			podSpec: corev1.PodSpec{Tolerations: tolerations()},
+		},
+		{
+			name:    "MissingToleration",
+			config:  &NamespaceConfig{Tolerations: tolerations()},
+			podSpec: corev1.PodSpec{Tolerations: tolerations()[:1]},
+			expectedViolations: []string{
+				`missing toleration for key "example-key-b" with effect "PreferNoSchedule"`,
+			},
+		},
+		{
+			name:    "NodeSelector",
+			config:  &NamespaceConfig{NodeSelector: map[string]string{"pool": "a", "zone": "b"}},
+			podSpec: corev1.PodSpec{NodeSelector: map[string]string{"pool": "b"}},
+			expectedViolations: []string{
+				"nodeSelector pool=a is missing",
+				"nodeSelector zone=b is missing",
+			},
+		},
+		{
+			name: "NodeSelectorWithReportPolicy",
+			config: &NamespaceConfig{
+				NodeSelector:               map[string]string{"pool": "a", "zone": "b"},
+				NodeSelectorConflictPolicy: v1alpha1.NodeSelectorConflictPolicyReport,
+			},
+			podSpec:            corev1.PodSpec{NodeSelector: map[string]string{"pool": "b"}},
+			expectedViolations: []string{"nodeSelector zone=b is missing"},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.expectedViolations, placementViolations(tc.config, tc.podSpec))
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name            string
+		pod             *corev1.Pod
+		expectedAllowed bool
+		expectedCode    int32
+	}{
+		{
+			name: "CompliantPod",
+			pod: &corev1.Pod{
+				Spec: corev1.PodSpec{Tolerations: tolerations()[:1]},
+			},
+			expectedAllowed: true,
+		},
+		{
+			name:         "ViolatingPod",
+			pod:          &corev1.Pod{},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name: "ExcludedPod",
+			pod: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{corev1.MirrorPodAnnotationKey: "mirror"},
+				},
+			},
+			expectedAllowed: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := newTestInjectorWithConfig(t, map[string]string{
+				"testing-ns": "{tolerations: [{key: example-key, operator: Exists, effect: NoSchedule}]}",
+			})
+
+			admissionReview := v1beta1.AdmissionReview{
+				Request: &v1beta1.AdmissionRequest{
+					Namespace: "testing-ns",
+					Object:    runtime.RawExtension{Object: tc.pod},
+				},
+			}
+			j, err := json.Marshal(admissionReview)
+			assert.NoError(t, err)
+
+			body, err := m.Validate(j)
+			assert.NoError(t, err)
+
+			resp := v1beta1.AdmissionReview{}
+			assert.NoError(t, json.Unmarshal(body, &resp))
+			assert.Equal(t, tc.expectedAllowed, resp.Response.Allowed)
+			assert.Equal(t, tc.expectedCode, resp.Response.Result.Code)
+			assert.Nil(t, resp.Response.Patch)
+
+			if !tc.expectedAllowed {
+				assert.Equal(t, `the pod violates the placement policy of namespace testing-ns: missing toleration for key "example-key" with effect "NoSchedule"`, resp.Response.Result.Message)
+			}
+		})
+	}
+}
+
+func TestValidateMutatedPod(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name   string
+		config string
+	}{
+		{
+			name:   "Append",
+			config: "{nodeSelectorTerms: [{matchExpressions: [{key: pool, operator: In, values: [a]}]}]}",
+		},
+		{
+			name:   "Enforce",
+			config: "{nodeSelectorTermsStrategy: enforce, nodeSelectorTerms: [{matchExpressions: [{key: pool, operator: In, values: [a]}]}]}",
+		},
+		{
+			name:   "Replace",
+			config: "{conflictStrategy: replace, nodeSelectorTerms: [{matchExpressions: [{key: pool, operator: In, values: [a]}]}]}",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := newTestInjectorWithConfig(t, map[string]string{"testing-ns": tc.config})
+
+			review := func(pod *corev1.Pod, webhook func([]byte) ([]byte, error)) *v1beta1.AdmissionResponse {
+				j, err := json.Marshal(v1beta1.AdmissionReview{
+					Request: &v1beta1.AdmissionRequest{
+						Namespace: "testing-ns",
+						Object:    runtime.RawExtension{Object: pod},
+					},
+				})
+				assert.NoError(t, err)
+
+				body, err := webhook(j)
+				assert.NoError(t, err)
+
+				resp := v1beta1.AdmissionReview{}
+				assert.NoError(t, json.Unmarshal(body, &resp))
+
+				return resp.Response
+			}
+
+			// The pods with node affinity of their own admitted by Mutate
+			// are admitted by Validate as well
+			pod := &corev1.Pod{Spec: podSpecWithExistingNodeSelectorTerms}
+			resp := review(pod, m.Mutate)
+			assert.True(t, resp.Allowed)
+
+			resp = review(applyPatch(t, pod, resp.Patch), m.Validate)
+			assert.True(t, resp.Allowed)
+		})
+	}
+}
+
+func TestValidateWithoutConfiguration(t *testing.T) {
+	t.Parallel()
+
+	m := newTestInjectorWithConfig(t, map[string]string{
+		"testing-ns": "{tolerations: [{key: example-key, operator: Exists, effect: NoSchedule}]}",
+	})
+
+	admissionReview := v1beta1.AdmissionReview{
+		Request: &v1beta1.AdmissionRequest{
+			Namespace: "other-ns",
+			Object:    runtime.RawExtension{Object: &corev1.Pod{}},
+		},
+	}
+	j, err := json.Marshal(admissionReview)
+	assert.NoError(t, err)
+
+	body, err := m.Validate(j)
+	assert.NoError(t, err)
+
+	resp := v1beta1.AdmissionReview{}
+	assert.NoError(t, json.Unmarshal(body, &resp))
+	assert.True(t, resp.Response.Allowed)
+	assert.Nil(t, resp.Response.Patch)
+}
+
+func TestValidateWithInvalidConfiguration(t *testing.T) {
+	t.Parallel()
+
+	m := newTestInjectorWithConfig(t, map[string]string{"testing-ns": "{tolerations: invalid}"})
+
+	admissionReview := v1beta1.AdmissionReview{
+		Request: &v1beta1.AdmissionRequest{
+			Namespace: "testing-ns",
+			Object:    runtime.RawExtension{Object: &corev1.Pod{}},
+		},
+	}
+	j, err := json.Marshal(admissionReview)
+	assert.NoError(t, err)
+
+	_, err = m.Validate(j)
+	assert.ErrorIs(t, err, ErrInvalidConfiguration)
+}
+
+func TestValidateWithInvalidBody(t *testing.T) {
+	t.Parallel()
+
+	m := newTestInjectorWithConfig(t, map[string]string{})
+
+	_, err := m.Validate([]byte("invalid"))
+	assert.ErrorIs(t, err, ErrInvalidAdmissionReview)
+}
@@ -0,0 +1,144 @@
+package injector
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/idgenchev/namespace-node-affinity/api/v1alpha1"
+)
+
+// patchChange is a kind of change made by the patches to a pod, e.g. adding
+// tolerations. Changes without plural are made once
+type patchChange struct {
+	verb     string
+	singular string
+	plural   string
+}
+
+// describe returns the items changed by count changes of c
+func (c patchChange) describe(count int) string {
+	if c.plural == "" {
+		return c.singular
+	}
+
+	noun := c.plural
+	if count == 1 {
+		noun = c.singular
+	}
+
+	return fmt.Sprintf("%d %s", count, noun)
+}
+
+// joinList joins items into a list, e.g. "a, b and c"
+func joinList(items []string) string {
+	if len(items) == 1 {
+		return items[0]
+	}
+
+	return strings.Join(items[:len(items)-1], ", ") + " and " + items[len(items)-1]
+}
+
+// patchChangeOf returns the change made by patch and the number of items it
+// changes. The patches which only initialise the lists other patches add to
+// and the config hash patch are not reported
+func patchChangeOf(patch JSONPatch) (patchChange, int, bool) {
+	path := string(patch.Path)
+	podAffinityPrefix := CreateAffinity + "/" + podAffinityKey + "/"
+	podAntiAffinityPrefix := CreateAffinity + "/" + podAntiAffinityKey + "/"
+
+	switch {
+	case patch.Op == "replace" && path == CreateNodeAffinity:
+		return patchChange{verb: "replaced", singular: "the node affinity of the pod"}, 1, true
+	case patch.Op == "replace" && path == AddNodeSelectorTerms:
+		return patchChange{verb: "combined", singular: "the nodeSelectorTerms of the pod with its nodeSelectorTerms"}, 1, true
+	case path == AddToNodeSelectorTerms:
+		return patchChange{"added", "nodeSelectorTerm", "nodeSelectorTerms"}, 1, true
+	case path == AddToPreferredNodeSelectorTerms:
+		return patchChange{"added", "preferred nodeSelectorTerm", "preferred nodeSelectorTerms"}, 1, true
+	case path == AddTolerations:
+		return patchChange{"added", "toleration", "tolerations"}, 1, true
+	case strings.HasPrefix(path, CreateTolerations+"/") && strings.HasSuffix(path, "/tolerationSeconds"):
+		return patchChange{"updated the tolerationSeconds of", "toleration", "tolerations"}, 1, true
+	case path == CreateNodeSelector:
+		nodeSelector, _ := patch.Value.(map[string]string)
+		return patchChange{"added", "nodeSelector label", "nodeSelector labels"}, len(nodeSelector), true
+	case strings.HasPrefix(path, CreateNodeSelector+"/"):
+		return patchChange{"added", "nodeSelector label", "nodeSelector labels"}, 1, true
+	case path == AddTopologySpreadConstraints:
+		return patchChange{"added", "topologySpreadConstraint", "topologySpreadConstraints"}, 1, true
+	case strings.HasPrefix(path, podAffinityPrefix) && strings.HasSuffix(path, "/-"):
+		return patchChange{"added", "podAffinity term", "podAffinity terms"}, 1, true
+	case strings.HasPrefix(path, podAntiAffinityPrefix) && strings.HasSuffix(path, "/-"):
+		return patchChange{"added", "podAntiAffinity term", "podAntiAffinity terms"}, 1, true
+	case path == SetPriorityClassName:
+		return patchChange{verb: "set", singular: "the priorityClassName"}, 1, true
+	case path == SetRuntimeClassName:
+		return patchChange{verb: "set", singular: "the runtimeClassName"}, 1, true
+	case path == SetSchedulerName:
+		return patchChange{verb: "set", singular: "the schedulerName"}, 1, true
+	}
+
+	return patchChange{}, 0, false
+}
+
+// patchWarning returns the warning summarising the changes made by patches
+// to a pod in namespace, e.g. "namespace X added 1 nodeSelectorTerm and 2
+// tolerations and set the priorityClassName". The returned bool is false when
+// patches don't change the pod
+func patchWarning(namespace string, patches []JSONPatch) (string, bool) {
+	var changes []patchChange
+	counts := map[patchChange]int{}
+
+	for _, patch := range patches {
+		change, count, ok := patchChangeOf(patch)
+		if !ok || count == 0 {
+			continue
+		}
+
+		if _, seen := counts[change]; !seen {
+			changes = append(changes, change)
+		}
+		counts[change] += count
+	}
+
+	if len(changes) == 0 {
+		return "", false
+	}
+
+	// The changes are grouped by verb in the order of the first change
+	var verbs []string
+	items := map[string][]string{}
+	for _, change := range changes {
+		if _, seen := items[change.verb]; !seen {
+			verbs = append(verbs, change.verb)
+		}
+		items[change.verb] = append(items[change.verb], change.describe(counts[change]))
+	}
+
+	groups := make([]string, 0, len(verbs))
+	for _, verb := range verbs {
+		groups = append(groups, verb+" "+joinList(items[verb]))
+	}
+	summary := joinList(groups)
+
+	return fmt.Sprintf("namespace %s %s", namespace, summary), true
+}
+
+// nodeAffinityConflictWarning returns the warning for a pod with node
+// affinity of its own admitted with conflictStrategy. The conflicts replacing
+// the node affinity of the pod or combining its nodeSelectorTerms with the
+// ones of the namespace (with the enforce termsStrategy) are reported by
+// patchWarning
+func nodeAffinityConflictWarning(namespace string, conflictStrategy v1alpha1.ConflictStrategy, termsStrategy v1alpha1.NodeSelectorTermsStrategy) (string, bool) {
+	switch conflictStrategy {
+	case "", v1alpha1.ConflictStrategyAppend:
+		if termsStrategy == v1alpha1.NodeSelectorTermsStrategyEnforce {
+			return "", false
+		}
+		return fmt.Sprintf("the node affinity of the pod conflicts with the placement policy of namespace %s, the node affinity of the namespace is added to it", namespace), true
+	case v1alpha1.ConflictStrategySkip:
+		return fmt.Sprintf("the node affinity of the pod conflicts with the placement policy of namespace %s, the pod is admitted without the placement policy of the namespace", namespace), true
+	}
+
+	return "", false
+}
@@ -0,0 +1,88 @@
+package injector
+
+import (
+	"testing"
+
+	"github.com/idgenchev/namespace-node-affinity/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestPatchWarning(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name            string
+		patches         []JSONPatch
+		expectedWarning string
+	}{
+		{
+			name: "InitAndConfigHashPatches",
+			patches: []JSONPatch{
+				{Op: "add", Path: CreateAffinity, Value: corev1.Affinity{}},
+				{Op: "add", Path: CreateAnnotations, Value: map[string]string{configHashAnnotationKey: "hash"}},
+			},
+		},
+		{
+			name: "SingleToleration",
+			patches: []JSONPatch{
+				{Op: "add", Path: CreateTolerations, Value: []corev1.Toleration{}},
+				{Op: "add", Path: AddTolerations, Value: tolerations()[0]},
+			},
+			expectedWarning: "namespace testing-ns added 1 toleration",
+		},
+		{
+			name: "ReplacedNodeAffinity",
+			patches: []JSONPatch{
+				{Op: "replace", Path: CreateNodeAffinity, Value: corev1.NodeAffinity{}},
+				{Op: "add", Path: AddTolerations, Value: tolerations()[0]},
+				{Op: "add", Path: AddTolerations, Value: tolerations()[1]},
+			},
+			expectedWarning: "namespace testing-ns replaced the node affinity of the pod and added 2 tolerations",
+		},
+		{
+			name: "GroupedByVerb",
+			patches: []JSONPatch{
+				{Op: "add", Path: AddToNodeSelectorTerms, Value: nodeSelectorTerms()[0]},
+				{Op: "add", Path: CreateNodeSelector, Value: map[string]string{"pool": "a", "zone": "b"}},
+				{Op: "add", Path: SetPriorityClassName, Value: "high"},
+				{Op: "add", Path: "/spec/tolerations/0/tolerationSeconds", Value: 10},
+				{Op: "add", Path: "/spec/affinity/podAntiAffinity/requiredDuringSchedulingIgnoredDuringExecution/-", Value: corev1.PodAffinityTerm{}},
+				{Op: "add", Path: SetSchedulerName, Value: "scheduler"},
+			},
+			expectedWarning: "namespace testing-ns added 1 nodeSelectorTerm, 2 nodeSelector labels and 1 podAntiAffinity term, set the priorityClassName and the schedulerName and updated the tolerationSeconds of 1 toleration",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			warning, ok := patchWarning("testing-ns", tc.patches)
+			assert.Equal(t, tc.expectedWarning != "", ok)
+			assert.Equal(t, tc.expectedWarning, warning)
+		})
+	}
+}
+
+func TestNodeAffinityConflictWarning(t *testing.T) {
+	t.Parallel()
+
+	_, ok := nodeAffinityConflictWarning("testing-ns", v1alpha1.ConflictStrategyReplace, "")
+	assert.False(t, ok, "the replaced node affinity is reported by patchWarning")
+
+	_, ok = nodeAffinityConflictWarning("testing-ns", v1alpha1.ConflictStrategyReject, "")
+	assert.False(t, ok, "rejected pods are not admitted")
+
+	_, ok = nodeAffinityConflictWarning("testing-ns", "", v1alpha1.NodeSelectorTermsStrategyEnforce)
+	assert.False(t, ok, "the combined nodeSelectorTerms are reported by patchWarning")
+
+	warning, ok := nodeAffinityConflictWarning("testing-ns", "", "")
+	assert.True(t, ok)
+	assert.Contains(t, warning, "namespace testing-ns")
+
+	warning, ok = nodeAffinityConflictWarning("testing-ns", v1alpha1.ConflictStrategySkip, v1alpha1.NodeSelectorTermsStrategyEnforce)
+	assert.True(t, ok)
+	assert.Contains(t, warning, "without the placement policy")
+}
@@ -0,0 +1,101 @@
+package injector
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// podTemplate is the location of the pod template in a workload
+type podTemplate struct {
+	// fields is the path to the pod template in the workload
+	fields []string
+	// ownerKind is the kind of the owner of the pods created from the
+	// template, which is matched against the excludedOwnerKinds
+	ownerKind string
+	// controllerKind is the kind of the workloads creating the workload from
+	// their own pod template, which is patched already. The workloads
+	// controlled by them are not patched, as their controllers compare the
+	// templates and create a new workload when they differ
+	controllerKind string
+}
+
+// podTemplates are the pod templates of the workload kinds patched by the
+// Injector created WithWorkloads
+var podTemplates = map[schema.GroupKind]podTemplate{
+	{Group: "apps", Kind: "Deployment"}:  {fields: []string{"spec", "template"}, ownerKind: "ReplicaSet"},
+	{Group: "apps", Kind: "StatefulSet"}: {fields: []string{"spec", "template"}, ownerKind: "StatefulSet"},
+	{Group: "apps", Kind: "DaemonSet"}:   {fields: []string{"spec", "template"}, ownerKind: "DaemonSet"},
+	{Group: "apps", Kind: "ReplicaSet"}:  {fields: []string{"spec", "template"}, ownerKind: "ReplicaSet", controllerKind: "Deployment"},
+	{Group: "batch", Kind: "Job"}:        {fields: []string{"spec", "template"}, ownerKind: "Job", controllerKind: "CronJob"},
+	{Group: "batch", Kind: "CronJob"}:    {fields: []string{"spec", "jobTemplate", "spec", "template"}, ownerKind: "Job"},
+}
+
+// WithWorkloads makes the Injector patch the pod templates of Deployments,
+// StatefulSets, DaemonSets, ReplicaSets, Jobs and CronJobs in addition to the
+// pods, so the effective placement is visible in the workloads
+func WithWorkloads() Option {
+	return func(m *Injector) {
+		m.workloads = true
+	}
+}
+
+func workloadKind(req *admissionv1.AdmissionRequest) schema.GroupKind {
+	return schema.GroupKind{Group: req.Kind.Group, Kind: req.Kind.Kind}
+}
+
+// podTargets returns the target for the pod template of the workload in req.
+// The pod of the target is owned by the owner kind of the pods created from
+// the template. No targets are returned for the workloads controlled by the
+// controllerKind
+func (t podTemplate) podTargets(req *admissionv1.AdmissionRequest) ([]podTarget, error) {
+	if t.controllerKind != "" {
+		var workload metav1.PartialObjectMetadata
+		if err := jsonUnmarshal(req.Object.Raw, &workload); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidAdmissionReviewObj, err)
+		}
+
+		if controller := metav1.GetControllerOf(&workload); controller != nil && controller.Kind == t.controllerKind {
+			log.Infof("Ignoring %s %s controlled by %s %s", req.Kind.Kind, req.Name, controller.Kind, controller.Name)
+			return nil, nil
+		}
+	}
+
+	raw := json.RawMessage(req.Object.Raw)
+	for _, field := range t.fields {
+		fields := map[string]json.RawMessage{}
+		if err := jsonUnmarshal(raw, &fields); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidAdmissionReviewObj, err)
+		}
+
+		raw = fields[field]
+	}
+
+	podTemplateSpec := corev1.PodTemplateSpec{}
+	if err := jsonUnmarshal(raw, &podTemplateSpec); err != nil {
+		return nil, fmt.Errorf("%w: invalid pod template of %s %s: %v", ErrInvalidAdmissionReviewObj, req.Kind.Kind, req.Name, err)
+	}
+
+	fields := map[string]json.RawMessage{}
+	if err := jsonUnmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("%w: invalid pod template of %s %s: %v", ErrInvalidAdmissionReviewObj, req.Kind.Kind, req.Name, err)
+	}
+
+	target := podTargetForTemplate(podTemplateSpec, "/"+strings.Join(t.fields, "/"))
+	target.missingMetadata = fields["metadata"] == nil || string(fields["metadata"]) == "null"
+
+	controller := true
+	target.pod.OwnerReferences = append(target.pod.OwnerReferences, metav1.OwnerReference{
+		Kind:       t.ownerKind,
+		Name:       req.Name,
+		Controller: &controller,
+	})
+
+	return []podTarget{target}, nil
+}
@@ -0,0 +1,351 @@
+package injector
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func workloadRequest(t *testing.T, kind metav1.GroupVersionKind, obj runtime.Object) *admissionv1.AdmissionRequest {
+	raw, err := json.Marshal(obj)
+	assert.NoError(t, err)
+
+	return &admissionv1.AdmissionRequest{
+		Kind:      kind,
+		Name:      "workload",
+		Namespace: "testing-ns",
+		Object:    runtime.RawExtension{Raw: raw},
+	}
+}
+
+func TestPodTargets(t *testing.T) {
+	t.Parallel()
+
+	template := corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "test"}},
+		Spec:       corev1.PodSpec{NodeSelector: map[string]string{"pool": "a"}},
+	}
+
+	testCases := []struct {
+		name              string
+		req               *admissionv1.AdmissionRequest
+		workloads         bool
+		expectedOk        bool
+		expectedSpecRoot  string
+		expectedOwnerKind string
+	}{
+		{
+			name:             "Pod",
+			req:              workloadRequest(t, metav1.GroupVersionKind{Version: "v1", Kind: "Pod"}, &corev1.Pod{ObjectMeta: template.ObjectMeta, Spec: template.Spec}),
+			expectedOk:       true,
+			expectedSpecRoot: "/spec",
+		},
+		{
+			name: "DeploymentWithoutWorkloads",
+			req: workloadRequest(t, metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, &appsv1.Deployment{
+				Spec: appsv1.DeploymentSpec{Template: template},
+			}),
+		},
+		{
+			name: "Deployment",
+			req: workloadRequest(t, metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, &appsv1.Deployment{
+				Spec: appsv1.DeploymentSpec{Template: template},
+			}),
+			workloads:         true,
+			expectedOk:        true,
+			expectedSpecRoot:  "/spec/template/spec",
+			expectedOwnerKind: "ReplicaSet",
+		},
+		{
+			name: "CronJob",
+			req: workloadRequest(t, metav1.GroupVersionKind{Group: "batch", Version: "v1", Kind: "CronJob"}, &batchv1.CronJob{
+				Spec: batchv1.CronJobSpec{
+					JobTemplate: batchv1.JobTemplateSpec{
+						Spec: batchv1.JobSpec{Template: template},
+					},
+				},
+			}),
+			workloads:         true,
+			expectedOk:        true,
+			expectedSpecRoot:  "/spec/jobTemplate/spec/template/spec",
+			expectedOwnerKind: "Job",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := newTestInjectorWithConfig(t, map[string]string{})
+			m.workloads = tc.workloads
+
+			targets, err := m.podTargets(tc.req)
+			assert.NoError(t, err)
+			if !tc.expectedOk {
+				assert.Empty(t, targets)
+				return
+			}
+
+			assert.Len(t, targets, 1)
+			pod := targets[0].pod
+			assert.Equal(t, tc.expectedSpecRoot, targets[0].specRoot)
+			assert.Equal(t, template.Labels, pod.Labels)
+			assert.Equal(t, template.Spec, pod.Spec)
+
+			if tc.expectedOwnerKind != "" {
+				assert.Equal(t, tc.expectedOwnerKind, pod.OwnerReferences[0].Kind)
+			}
+		})
+	}
+}
+
+func TestPodTargetsWithInvalidTemplate(t *testing.T) {
+	t.Parallel()
+
+	m := newTestInjectorWithConfig(t, map[string]string{})
+	m.workloads = true
+
+	req := &admissionv1.AdmissionRequest{
+		Kind:   metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+		Object: runtime.RawExtension{Raw: []byte(`{"spec": {"template": "invalid"}}`)},
+	}
+
+	_, err := m.podTargets(req)
+	assert.ErrorIs(t, err, ErrInvalidAdmissionReviewObj)
+}
+
+// mutateWorkload returns the response of Mutate for the workload obj of kind
+func mutateWorkload(t *testing.T, m *Injector, kind metav1.GroupVersionKind, obj runtime.Object) *admissionv1.AdmissionResponse {
+	admissionReview := admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request:  workloadRequest(t, kind, obj),
+	}
+	j, err := json.Marshal(admissionReview)
+	assert.NoError(t, err)
+
+	body, err := m.Mutate(j)
+	assert.NoError(t, err)
+
+	resp := admissionv1.AdmissionReview{}
+	assert.NoError(t, json.Unmarshal(body, &resp))
+
+	return resp.Response
+}
+
+func TestMutateWorkloads(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		kind          metav1.GroupVersionKind
+		obj           runtime.Object
+		expectedPatch []JSONPatch
+	}{
+		{
+			name: "StatefulSet",
+			kind: metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "StatefulSet"},
+			obj:  &appsv1.StatefulSet{},
+			expectedPatch: []JSONPatch{
+				{Op: "add", Path: "/spec/template/spec/nodeSelector", Value: map[string]interface{}{"pool": "a"}},
+			},
+		},
+		{
+			name: "Job",
+			kind: metav1.GroupVersionKind{Group: "batch", Version: "v1", Kind: "Job"},
+			obj: &batchv1.Job{
+				Spec: batchv1.JobSpec{
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"a": "b"}},
+					},
+				},
+			},
+			expectedPatch: []JSONPatch{
+				{Op: "add", Path: "/spec/template/spec/nodeSelector", Value: map[string]interface{}{"pool": "a"}},
+			},
+		},
+		{
+			name: "DaemonSetsAreExcludedByDefault",
+			kind: metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "DaemonSet"},
+			obj:  &appsv1.DaemonSet{},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := newTestInjectorWithConfig(t, map[string]string{"testing-ns": "{nodeSelector: {pool: a}}"})
+			m.workloads = true
+
+			resp := mutateWorkload(t, m, tc.kind, tc.obj)
+			assert.True(t, resp.Allowed)
+
+			if tc.expectedPatch == nil {
+				assert.Nil(t, resp.Patch)
+				return
+			}
+
+			patch, err := jsonpatch.DecodePatch(resp.Patch)
+			assert.NoError(t, err)
+			_, err = patch.Apply(workloadRequest(t, tc.kind, tc.obj).Object.Raw)
+			assert.NoError(t, err, "the patch cannot be applied to the workload")
+
+			var patches []JSONPatch
+			assert.NoError(t, json.Unmarshal(resp.Patch, &patches))
+			// The config annotations are added to the template
+			var specPatches []JSONPatch
+			for _, patch := range patches {
+				if !strings.HasPrefix(string(patch.Path), "/spec/template/metadata/annotations") {
+					specPatches = append(specPatches, patch)
+				}
+			}
+			assert.Equal(t, tc.expectedPatch, specPatches)
+			assert.Greater(t, len(patches), len(specPatches))
+		})
+	}
+}
+
+func TestMutateControlledWorkloads(t *testing.T) {
+	t.Parallel()
+
+	m := newTestInjectorWithConfig(t, map[string]string{
+		"testing-ns": "{nodeSelectorTerms: [{matchExpressions: [{key: pool, operator: In, values: [a]}]}]}",
+	})
+	m.workloads = true
+
+	deploymentKind := metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+	replicaSetKind := metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "ReplicaSet"}
+	controller := true
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "workload"},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{Spec: podSpecWithExistingNodeSelectorTerms},
+		},
+	}
+	resp := mutateWorkload(t, m, deploymentKind, deployment)
+	assert.True(t, resp.Allowed)
+	assert.NotNil(t, resp.Patch)
+
+	// The Deployment controller creates the ReplicaSet from the patched
+	// template of the Deployment
+	deployment = applyPatch(t, deployment, resp.Patch)
+	replicaSet := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			OwnerReferences: []metav1.OwnerReference{{Kind: "Deployment", Name: "workload", Controller: &controller}},
+		},
+		Spec: appsv1.ReplicaSetSpec{Template: deployment.Spec.Template},
+	}
+
+	resp = mutateWorkload(t, m, replicaSetKind, replicaSet)
+	assert.True(t, resp.Allowed)
+	assert.Nil(t, resp.Patch)
+
+	// Jobs created by a CronJob are not patched either
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			OwnerReferences: []metav1.OwnerReference{{Kind: "CronJob", Name: "workload", Controller: &controller}},
+		},
+	}
+
+	resp = mutateWorkload(t, m, metav1.GroupVersionKind{Group: "batch", Version: "v1", Kind: "Job"}, job)
+	assert.True(t, resp.Allowed)
+	assert.Nil(t, resp.Patch)
+
+	// ReplicaSets without a Deployment controller are patched
+	replicaSet.OwnerReferences = nil
+	replicaSet.Spec.Template = corev1.PodTemplateSpec{}
+
+	resp = mutateWorkload(t, m, replicaSetKind, replicaSet)
+	assert.True(t, resp.Allowed)
+	assert.NotNil(t, resp.Patch)
+}
+
+func TestWorkloadPatchesApply(t *testing.T) {
+	t.Parallel()
+
+	config := `{
+		nodeSelectorTerms: [{matchExpressions: [{key: pool, operator: In, values: [a]}]}],
+		preferredNodeSelectorTerms: [{weight: 1, preference: {matchExpressions: [{key: zone, operator: In, values: [b]}]}}],
+		tolerations: [{key: a, operator: Exists, effect: NoSchedule}, {key: b, operator: Exists, effect: NoSchedule}],
+		podAntiAffinity: {preferredDuringSchedulingIgnoredDuringExecution: [{weight: 1, podAffinityTerm: {topologyKey: zone}}]},
+		topologySpreadConstraints: [{maxSkew: 1, topologyKey: zone, whenUnsatisfiable: ScheduleAnyway}],
+		nodeSelector: {disk: ssd}
+	}`
+
+	// The templates have no tolerations and no affinity, so the patches
+	// have to create them
+	testCases := []struct {
+		name     string
+		kind     metav1.GroupVersionKind
+		obj      runtime.Object
+		template func(t *testing.T, obj runtime.Object, patch []byte) corev1.PodTemplateSpec
+	}{
+		{
+			name: "Deployment",
+			kind: metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+			obj:  &appsv1.Deployment{},
+			template: func(t *testing.T, obj runtime.Object, patch []byte) corev1.PodTemplateSpec {
+				return applyPatch(t, obj.(*appsv1.Deployment), patch).Spec.Template
+			},
+		},
+		{
+			name: "StatefulSet",
+			kind: metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "StatefulSet"},
+			obj: &appsv1.StatefulSet{
+				Spec: appsv1.StatefulSetSpec{
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"a": "b"}},
+					},
+				},
+			},
+			template: func(t *testing.T, obj runtime.Object, patch []byte) corev1.PodTemplateSpec {
+				return applyPatch(t, obj.(*appsv1.StatefulSet), patch).Spec.Template
+			},
+		},
+		{
+			name: "CronJob",
+			kind: metav1.GroupVersionKind{Group: "batch", Version: "v1", Kind: "CronJob"},
+			obj:  &batchv1.CronJob{},
+			template: func(t *testing.T, obj runtime.Object, patch []byte) corev1.PodTemplateSpec {
+				return applyPatch(t, obj.(*batchv1.CronJob), patch).Spec.JobTemplate.Spec.Template
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := newTestInjectorWithConfig(t, map[string]string{"testing-ns": config})
+			m.workloads = true
+
+			resp := mutateWorkload(t, m, tc.kind, tc.obj)
+			assert.True(t, resp.Allowed)
+
+			template := tc.template(t, tc.obj, resp.Patch)
+			spec := template.Spec
+			if assert.NotNil(t, spec.Affinity) && assert.NotNil(t, spec.Affinity.NodeAffinity) {
+				assert.Len(t, podNodeSelectorTerms(spec), 1)
+				assert.Len(t, spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution, 1)
+				assert.Len(t, spec.Affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution, 1)
+			}
+			assert.Len(t, spec.Tolerations, 2)
+			assert.Len(t, spec.TopologySpreadConstraints, 1)
+			assert.Equal(t, map[string]string{"disk": "ssd"}, spec.NodeSelector)
+			assert.Contains(t, template.Annotations, configHashAnnotationKey)
+		})
+	}
+}
@@ -1,5 +1,6 @@
 // Package webhookconfig deals with creating or updating
-// MutatingWebhookConfiguration for the namespace-node-affinity webhook
+// MutatingWebhookConfiguration and ValidatingWebhookConfiguration for the
+// namespace-node-affinity webhook
 package webhookconfig
 
 import (
@@ -10,9 +11,17 @@ import (
 	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	k8sclient "k8s.io/client-go/kubernetes"
 )
 
+// The webhooks are called for the namespaces labelled with
+// NamespaceLabel=NamespaceLabelEnabled
+const (
+	NamespaceLabel        = "namespace-node-affinity"
+	NamespaceLabelEnabled = "enabled"
+)
+
 func failurePolicy() *admissionregistrationv1.FailurePolicyType {
 	policy := admissionregistrationv1.Ignore
 	return &policy
@@ -23,17 +32,74 @@ func sideEffect() *admissionregistrationv1.SideEffectClass {
 	return &sideEffectClass
 }
 
+// mutateSideEffect is the side effect class of the mutating webhook, which
+// can emit events about the configuration of a namespace except on dry runs
+func mutateSideEffect() *admissionregistrationv1.SideEffectClass {
+	sideEffectClass := admissionregistrationv1.SideEffectClassNoneOnDryRun
+	return &sideEffectClass
+}
+
 func path() *string {
 	p := "/mutate"
 	return &p
 }
 
+func validatePath() *string {
+	p := "/validate"
+	return &p
+}
+
+// workloadRules are the rules for the workloads with pod templates. The
+// ReplicaSets and Jobs created by Deployments and CronJobs are admitted
+// without changes by the webhook
+func workloadRules() []admissionregistrationv1.RuleWithOperations {
+	return []admissionregistrationv1.RuleWithOperations{
+		{
+			Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+			Rule: admissionregistrationv1.Rule{
+				APIGroups:   []string{"apps"},
+				APIVersions: []string{"v1"},
+				Resources:   []string{"deployments", "statefulsets", "daemonsets", "replicasets"},
+			},
+		},
+		{
+			Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+			Rule: admissionregistrationv1.Rule{
+				APIGroups:   []string{"batch"},
+				APIVersions: []string{"v1"},
+				Resources:   []string{"jobs", "cronjobs"},
+			},
+		},
+	}
+}
+
+// customResourceRules are the rules for the custom resources with pod
+// templates or pod specs
+func customResourceRules(customResources []schema.GroupVersionResource) []admissionregistrationv1.RuleWithOperations {
+	rules := make([]admissionregistrationv1.RuleWithOperations, 0, len(customResources))
+	for _, gvr := range customResources {
+		rules = append(rules, admissionregistrationv1.RuleWithOperations{
+			Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+			Rule: admissionregistrationv1.Rule{
+				APIGroups:   []string{gvr.Group},
+				APIVersions: []string{gvr.Version},
+				Resources:   []string{gvr.Resource},
+			},
+		})
+	}
+
+	return rules
+}
+
 // CreateOrUpdateMutatingWebhookConfig creates "namespace-node-affinity"
-// mutating webhook configuration with "Ignore" failure policy for pods
-// or returns an error
+// mutating webhook configuration with "Ignore" failure policy and the given
+// reinvocation policy for pods, for the workloads with pod templates when
+// mutateWorkloads is set and for customResources, or returns an error. The webhook does not patch
+// pods with the configuration already applied, so "IfNeeded" is safe to use
 // NOTE: If the MutatingWebhookConfiguration already exists, the only
-// thing that will be updated is the CABundle
-func CreateOrUpdateMutatingWebhookConfig(k8sClient k8sclient.Interface, caBundle *bytes.Buffer, namespace, name, serviceName string) error {
+// things that will be updated are the CABundle, the reinvocation policy and
+// the rules
+func CreateOrUpdateMutatingWebhookConfig(k8sClient k8sclient.Interface, caBundle *bytes.Buffer, namespace, name, serviceName string, reinvocationPolicy admissionregistrationv1.ReinvocationPolicyType, mutateWorkloads bool, customResources []schema.GroupVersionResource) error {
 	webhookName := fmt.Sprintf("%s.%s.svc", serviceName, namespace)
 
 	mutateconfig := &admissionregistrationv1.MutatingWebhookConfiguration{
@@ -43,7 +109,7 @@ func CreateOrUpdateMutatingWebhookConfig(k8sClient k8sclient.Interface, caBundle
 		Webhooks: []admissionregistrationv1.MutatingWebhook{
 			{
 				Name:                    webhookName,
-				SideEffects:             sideEffect(),
+				SideEffects:             mutateSideEffect(),
 				AdmissionReviewVersions: []string{"v1"},
 				ClientConfig: admissionregistrationv1.WebhookClientConfig{
 					CABundle: caBundle.Bytes(),
@@ -63,16 +129,23 @@ func CreateOrUpdateMutatingWebhookConfig(k8sClient k8sclient.Interface, caBundle
 						},
 					},
 				},
-				FailurePolicy: failurePolicy(),
+				FailurePolicy:      failurePolicy(),
+				ReinvocationPolicy: &reinvocationPolicy,
 				NamespaceSelector: &metav1.LabelSelector{
 					MatchLabels: map[string]string{
-						"namespace-node-affinity": "enabled",
+						NamespaceLabel: NamespaceLabelEnabled,
 					},
 				},
 			},
 		},
 	}
 
+	if mutateWorkloads {
+		mutateconfig.Webhooks[0].Rules = append(mutateconfig.Webhooks[0].Rules, workloadRules()...)
+	}
+
+	mutateconfig.Webhooks[0].Rules = append(mutateconfig.Webhooks[0].Rules, customResourceRules(customResources)...)
+
 	if _, err := k8sClient.AdmissionregistrationV1().MutatingWebhookConfigurations().Create(context.Background(), mutateconfig, metav1.CreateOptions{}); err != nil {
 		if k8serrors.IsAlreadyExists(err) {
 			existingConf, err := k8sClient.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.Background(), name, metav1.GetOptions{})
@@ -90,3 +163,65 @@ func CreateOrUpdateMutatingWebhookConfig(k8sClient k8sclient.Interface, caBundle
 
 	return nil
 }
+
+// CreateOrUpdateValidatingWebhookConfig creates the validating webhook
+// configuration rejecting pods which violate the placement policy of their
+// namespace with the given failure policy or returns an error
+// NOTE: If the ValidatingWebhookConfiguration already exists, it is replaced
+func CreateOrUpdateValidatingWebhookConfig(k8sClient k8sclient.Interface, caBundle *bytes.Buffer, namespace, name, serviceName string, failurePolicy admissionregistrationv1.FailurePolicyType) error {
+	webhookName := fmt.Sprintf("%s.%s.svc", serviceName, namespace)
+
+	validateconfig := &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{
+			{
+				Name:                    webhookName,
+				SideEffects:             sideEffect(),
+				AdmissionReviewVersions: []string{"v1"},
+				ClientConfig: admissionregistrationv1.WebhookClientConfig{
+					CABundle: caBundle.Bytes(),
+					Service: &admissionregistrationv1.ServiceReference{
+						Name:      serviceName,
+						Namespace: namespace,
+						Path:      validatePath(),
+					},
+				},
+				Rules: []admissionregistrationv1.RuleWithOperations{
+					{
+						Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+						Rule: admissionregistrationv1.Rule{
+							APIGroups:   []string{""},
+							APIVersions: []string{"v1"},
+							Resources:   []string{"pods"},
+						},
+					},
+				},
+				FailurePolicy: &failurePolicy,
+				NamespaceSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{
+						NamespaceLabel: NamespaceLabelEnabled,
+					},
+				},
+			},
+		},
+	}
+
+	if _, err := k8sClient.AdmissionregistrationV1().ValidatingWebhookConfigurations().Create(context.Background(), validateconfig, metav1.CreateOptions{}); err != nil {
+		if k8serrors.IsAlreadyExists(err) {
+			existingConf, err := k8sClient.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.Background(), name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+
+			// The metadata.resourceVersion needs to be specified for an update
+			validateconfig.ResourceVersion = existingConf.ResourceVersion
+			_, err = k8sClient.AdmissionregistrationV1().ValidatingWebhookConfigurations().Update(context.Background(), validateconfig, metav1.UpdateOptions{})
+			return err
+		}
+		return err
+	}
+
+	return nil
+}
@@ -11,6 +11,7 @@ import (
 	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	fake "k8s.io/client-go/kubernetes/fake"
 	fakeadmissionregistrationv1 "k8s.io/client-go/kubernetes/typed/admissionregistration/v1/fake"
 	k8stesting "k8s.io/client-go/testing"
@@ -28,13 +29,17 @@ func caBundle(contents string) *bytes.Buffer {
 	return caBundle
 }
 
+func reinvocationPolicy(policy admissionregistrationv1.ReinvocationPolicyType) *admissionregistrationv1.ReinvocationPolicyType {
+	return &policy
+}
+
 func TestCreateMutatingWebhookConfig(t *testing.T) {
 	t.Parallel()
 
 	clientset := fake.NewSimpleClientset()
 
 	bundle := caBundle("asdasd")
-	err := CreateOrUpdateMutatingWebhookConfig(clientset, bundle, namespace, webhookConfigName, serviceName)
+	err := CreateOrUpdateMutatingWebhookConfig(clientset, bundle, namespace, webhookConfigName, serviceName, admissionregistrationv1.NeverReinvocationPolicy, false, nil)
 	assert.NoError(t, err)
 
 	expectedConfig := &admissionregistrationv1.MutatingWebhookConfiguration{
@@ -44,7 +49,7 @@ func TestCreateMutatingWebhookConfig(t *testing.T) {
 		Webhooks: []admissionregistrationv1.MutatingWebhook{
 			{
 				Name:                    fmt.Sprintf("%s.%s.svc", serviceName, namespace),
-				SideEffects:             sideEffect(),
+				SideEffects:             mutateSideEffect(),
 				AdmissionReviewVersions: []string{"v1"},
 				ClientConfig: admissionregistrationv1.WebhookClientConfig{
 					CABundle: bundle.Bytes(),
@@ -64,7 +69,8 @@ func TestCreateMutatingWebhookConfig(t *testing.T) {
 						},
 					},
 				},
-				FailurePolicy: failurePolicy(),
+				FailurePolicy:      failurePolicy(),
+				ReinvocationPolicy: reinvocationPolicy(admissionregistrationv1.NeverReinvocationPolicy),
 				NamespaceSelector: &metav1.LabelSelector{
 					MatchLabels: map[string]string{
 						"namespace-node-affinity": "enabled",
@@ -92,7 +98,7 @@ func TestUpdateMutatingWebhookConfig(t *testing.T) {
 		Webhooks: []admissionregistrationv1.MutatingWebhook{
 			{
 				Name:                    fmt.Sprintf("%s.%s.svc", serviceName, namespace),
-				SideEffects:             sideEffect(),
+				SideEffects:             mutateSideEffect(),
 				AdmissionReviewVersions: []string{"v1"},
 				ClientConfig: admissionregistrationv1.WebhookClientConfig{
 					CABundle: initialBundle.Bytes(),
@@ -125,13 +131,14 @@ func TestUpdateMutatingWebhookConfig(t *testing.T) {
 	clientset := fake.NewSimpleClientset(existingConfig)
 
 	newBundle := caBundle("newcabundle")
-	err := CreateOrUpdateMutatingWebhookConfig(clientset, newBundle, namespace, webhookConfigName, serviceName)
+	err := CreateOrUpdateMutatingWebhookConfig(clientset, newBundle, namespace, webhookConfigName, serviceName, admissionregistrationv1.IfNeededReinvocationPolicy, false, nil)
 	assert.NoError(t, err)
 
 	newConfig, err := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.Background(), webhookConfigName, metav1.GetOptions{})
 
 	assert.NoError(t, err)
 	assert.Equal(t, newBundle.Bytes(), newConfig.Webhooks[0].ClientConfig.CABundle)
+	assert.Equal(t, reinvocationPolicy(admissionregistrationv1.IfNeededReinvocationPolicy), newConfig.Webhooks[0].ReinvocationPolicy)
 
 	// Make sure we've set the ResourceVersion. The fake client is
 	// returning whatever is passed to the Update, so the
@@ -148,7 +155,7 @@ func TestCreateMutatingWebhookConfigWithError(t *testing.T) {
 	})
 
 	bundle := caBundle("asdasd")
-	err := CreateOrUpdateMutatingWebhookConfig(clientset, bundle, namespace, webhookConfigName, serviceName)
+	err := CreateOrUpdateMutatingWebhookConfig(clientset, bundle, namespace, webhookConfigName, serviceName, admissionregistrationv1.NeverReinvocationPolicy, false, nil)
 	assert.Equal(t, expectedErr, err)
 }
 
@@ -166,6 +173,166 @@ func TestFailingToGetExistingWebhook(t *testing.T) {
 	})
 
 	bundle := caBundle("asdasd")
-	err := CreateOrUpdateMutatingWebhookConfig(clientset, bundle, namespace, webhookConfigName, serviceName)
+	err := CreateOrUpdateMutatingWebhookConfig(clientset, bundle, namespace, webhookConfigName, serviceName, admissionregistrationv1.NeverReinvocationPolicy, false, nil)
+	assert.Equal(t, expectedErr, err)
+}
+
+func TestCreateMutatingWebhookConfigWithWorkloads(t *testing.T) {
+	t.Parallel()
+
+	clientset := fake.NewSimpleClientset()
+
+	err := CreateOrUpdateMutatingWebhookConfig(clientset, caBundle("asdasd"), namespace, webhookConfigName, serviceName, admissionregistrationv1.IfNeededReinvocationPolicy, true, nil)
+	assert.NoError(t, err)
+
+	actualConfig, err := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.Background(), webhookConfigName, metav1.GetOptions{})
+	assert.NoError(t, err)
+
+	expectedRules := []admissionregistrationv1.RuleWithOperations{
+		{
+			Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+			Rule: admissionregistrationv1.Rule{
+				APIGroups:   []string{""},
+				APIVersions: []string{"v1"},
+				Resources:   []string{"pods"},
+			},
+		},
+		{
+			Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+			Rule: admissionregistrationv1.Rule{
+				APIGroups:   []string{"apps"},
+				APIVersions: []string{"v1"},
+				Resources:   []string{"deployments", "statefulsets", "daemonsets", "replicasets"},
+			},
+		},
+		{
+			Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+			Rule: admissionregistrationv1.Rule{
+				APIGroups:   []string{"batch"},
+				APIVersions: []string{"v1"},
+				Resources:   []string{"jobs", "cronjobs"},
+			},
+		},
+	}
+	assert.Equal(t, expectedRules, actualConfig.Webhooks[0].Rules)
+}
+
+func TestCreateMutatingWebhookConfigWithCustomResources(t *testing.T) {
+	t.Parallel()
+
+	clientset := fake.NewSimpleClientset()
+
+	customResources := []schema.GroupVersionResource{{Group: "ray.io", Version: "v1", Resource: "rayclusters"}}
+	err := CreateOrUpdateMutatingWebhookConfig(clientset, caBundle("asdasd"), namespace, webhookConfigName, serviceName, admissionregistrationv1.NeverReinvocationPolicy, false, customResources)
+	assert.NoError(t, err)
+
+	actualConfig, err := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.Background(), webhookConfigName, metav1.GetOptions{})
+	assert.NoError(t, err)
+
+	expectedRules := []admissionregistrationv1.RuleWithOperations{
+		{
+			Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+			Rule: admissionregistrationv1.Rule{
+				APIGroups:   []string{""},
+				APIVersions: []string{"v1"},
+				Resources:   []string{"pods"},
+			},
+		},
+		{
+			Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+			Rule: admissionregistrationv1.Rule{
+				APIGroups:   []string{"ray.io"},
+				APIVersions: []string{"v1"},
+				Resources:   []string{"rayclusters"},
+			},
+		},
+	}
+	assert.Equal(t, expectedRules, actualConfig.Webhooks[0].Rules)
+}
+
+func validatingWebhookConfig(bundle *bytes.Buffer, failurePolicy admissionregistrationv1.FailurePolicyType) *admissionregistrationv1.ValidatingWebhookConfiguration {
+	return &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: webhookConfigName,
+		},
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{
+			{
+				Name:                    fmt.Sprintf("%s.%s.svc", serviceName, namespace),
+				SideEffects:             sideEffect(),
+				AdmissionReviewVersions: []string{"v1"},
+				ClientConfig: admissionregistrationv1.WebhookClientConfig{
+					CABundle: bundle.Bytes(),
+					Service: &admissionregistrationv1.ServiceReference{
+						Name:      serviceName,
+						Namespace: namespace,
+						Path:      validatePath(),
+					},
+				},
+				Rules: []admissionregistrationv1.RuleWithOperations{
+					{
+						Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+						Rule: admissionregistrationv1.Rule{
+							APIGroups:   []string{""},
+							APIVersions: []string{"v1"},
+							Resources:   []string{"pods"},
+						},
+					},
+				},
+				FailurePolicy: &failurePolicy,
+				NamespaceSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{
+						"namespace-node-affinity": "enabled",
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestCreateValidatingWebhookConfig(t *testing.T) {
+	t.Parallel()
+
+	clientset := fake.NewSimpleClientset()
+
+	bundle := caBundle("asdasd")
+	err := CreateOrUpdateValidatingWebhookConfig(clientset, bundle, namespace, webhookConfigName, serviceName, admissionregistrationv1.Fail)
+	assert.NoError(t, err)
+
+	actualConfig, err := clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.Background(), webhookConfigName, metav1.GetOptions{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, validatingWebhookConfig(bundle, admissionregistrationv1.Fail), actualConfig)
+}
+
+func TestUpdateValidatingWebhookConfig(t *testing.T) {
+	t.Parallel()
+
+	existingConfig := validatingWebhookConfig(caBundle("initialcabundle"), admissionregistrationv1.Ignore)
+	existingConfig.ResourceVersion = "testv"
+
+	clientset := fake.NewSimpleClientset(existingConfig)
+
+	newBundle := caBundle("newcabundle")
+	err := CreateOrUpdateValidatingWebhookConfig(clientset, newBundle, namespace, webhookConfigName, serviceName, admissionregistrationv1.Fail)
+	assert.NoError(t, err)
+
+	newConfig, err := clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.Background(), webhookConfigName, metav1.GetOptions{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, newBundle.Bytes(), newConfig.Webhooks[0].ClientConfig.CABundle)
+	assert.Equal(t, admissionregistrationv1.Fail, *newConfig.Webhooks[0].FailurePolicy)
+	assert.Equal(t, existingConfig.ResourceVersion, newConfig.ResourceVersion)
+}
+
+func TestCreateValidatingWebhookConfigWithError(t *testing.T) {
+	expectedErr := errors.New("create err")
+
+	clientset := fake.NewSimpleClientset()
+	clientset.AdmissionregistrationV1().(*fakeadmissionregistrationv1.FakeAdmissionregistrationV1).PrependReactor("create", "*", func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+		return true, nil, expectedErr
+	})
+
+	bundle := caBundle("asdasd")
+	err := CreateOrUpdateValidatingWebhookConfig(clientset, bundle, namespace, webhookConfigName, serviceName, admissionregistrationv1.Ignore)
 	assert.Equal(t, expectedErr, err)
 }